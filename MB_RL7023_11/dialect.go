@@ -0,0 +1,79 @@
+package MB_RL7023_11
+
+import "fmt"
+
+// モジュールごとのコマンドやイベントの書式の違い
+type Dialect struct {
+	// ERXUDP の送信元リンクローカルアドレスの後に RSSI がある
+	ERXUDPRSSI bool
+	// ERXUDP の暗号化の有無の後にサイドがある
+	ERXUDPSide bool
+	// SKSENDTO の暗号化の指定の後にサイドを指定する
+	SKSENDTOSide bool
+	// SKSCAN の最後にサイドを指定する
+	SKSCANSide bool
+	// EPANDESC に Side の行がある
+	EPANDESCSide bool
+}
+
+// MB-RL7023-11 の書式
+var DefaultDialect = Dialect{
+	ERXUDPSide:   true,
+	SKSENDTOSide: true,
+	SKSCANSide:   true,
+	EPANDESCSide: true,
+}
+
+// 受信した行をイベントに変換します。
+func (d Dialect) ParseEvent(l []string) []any {
+	return parseEvent(l, d)
+}
+
+func (d Dialect) NewERXUDP(line string) (*ERXUDP, error) {
+	return newERXUDP(line, d)
+}
+
+func (d Dialect) NewEPANDESC(lines []string) (*EPANDESC, error) {
+	return newEPANDESC(lines, d.EPANDESCSide)
+}
+
+func (d Dialect) sendtoCommand(handle uint8, ipaddr string, port uint16, sec SKSENDTOSec, reserved SKSENDTOReserved, payload []uint8) string {
+	if !d.SKSENDTOSide {
+		return fmt.Sprintf("SKSENDTO %X %s %04X %X %04X ", handle, ipaddr, port, sec, len(payload))
+	}
+	return fmt.Sprintf("SKSENDTO %X %s %04X %X %X %04X ", handle, ipaddr, port, sec, reserved, len(payload))
+}
+
+func (d Dialect) scanCommand(mode SKSCANMode, channelMask uint32, duration uint8, reserved SKSCANReserved) string {
+	command := fmt.Sprintf("SKSCAN %X %08X %X", mode, channelMask, duration)
+	if d.SKSCANSide {
+		command += fmt.Sprintf(" %X", reserved)
+	}
+	return command
+}
+
+// バイナリ表示のデータ部を含むイベントごとの、データ長までのフィールドの数
+func (d Dialect) binaryFields() map[string]int {
+	// 送信元、宛先、ポート 2 つ、リンクローカルアドレス、暗号化の有無、データ長
+	erxudp := 7
+	if d.ERXUDPRSSI {
+		erxudp++
+	}
+	if d.ERXUDPSide {
+		erxudp++
+	}
+	return map[string]int{
+		ERXUDP_ID: erxudp,
+		ERXTCP_ID: 4,
+	}
+}
+
+// モジュールの書式を変更します。受信済みのイベントには影響しません。
+func (m *MB_RL7023_11) SetDialect(d Dialect) {
+	m.dialect.Store(&d)
+	m.setPayloadMode(WOPTMode(m.opt.Load()))
+}
+
+func (m *MB_RL7023_11) Dialect() Dialect {
+	return *m.dialect.Load()
+}
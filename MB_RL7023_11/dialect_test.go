@@ -0,0 +1,41 @@
+package MB_RL7023_11
+
+import (
+	"errors"
+	"testing"
+)
+
+// バイナリ表示のデータ長までのフィールドの数が、ERXUDP の書式と一致する
+func TestDialectBinaryFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		want    int
+	}{
+		{"mb-rl7023-11", DefaultDialect, 8},
+		{"without side", Dialect{}, 7},
+		{"with rssi", Dialect{ERXUDPRSSI: true, ERXUDPSide: true}, 9},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.binaryFields()[ERXUDP_ID]; got != tt.want {
+			t.Errorf("%s: ERXUDP fields = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDialectEPANDESCSide(t *testing.T) {
+	lines := []string{"EPANDESC", "  Channel:21", "  Pan ID:1234", "  Addr:001D129012345678", "  LQI:50"}
+
+	_, err := Dialect{EPANDESCSide: true}.NewEPANDESC(lines)
+	if !errors.Is(err, ErrInvalidEventFormat) {
+		t.Errorf("NewEPANDESC without Side = %v, want %v", err, ErrInvalidEventFormat)
+	}
+
+	e, err := Dialect{}.NewEPANDESC(append(lines, "  Side:1"))
+	if err != nil {
+		t.Fatalf("NewEPANDESC: %v", err)
+	}
+	if e.Side != 0 {
+		t.Errorf("Side = %d, want 0 for a dialect without side", e.Side)
+	}
+}
@@ -0,0 +1,135 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrTransmitLimited = errors.New("transmission rate limit exceeded")
+
+const (
+	// 920MHz 帯の送信時間の制限、1 時間あたりの送信時間の合計
+	TransmitTimeBudget = 360 * time.Second
+	transmitTimeWindow = 1 * time.Hour
+)
+
+// 送信時間の制限の状態
+type transmitState struct {
+	limited bool
+	// 制限が解除されると閉じる
+	released chan struct{}
+	// 積算送信時間を読み出した記録、1 時間分を保持する
+	samples []transmitSample
+}
+
+type transmitSample struct {
+	at    time.Time
+	total time.Duration
+}
+
+// 直近 1 時間の送信時間
+type TransmitUsage struct {
+	// 送信時間の制限中
+	Limited bool
+	// 直近 1 時間に送信した時間
+	Used time.Duration
+	// 制限までに送信できる残りの時間
+	Remaining time.Duration
+}
+
+// EVENT 32、33 で送信時間の制限の状態を更新します。
+func (m *MB_RL7023_11) observeTransmit(e *EVENT) {
+	switch e.Num {
+	case EVENTNumTransmissionRateLimitExceeded:
+		m.logger.Warn("Transmission rate limit exceeded, holding transmissions")
+		m.setTransmitLimited(true)
+	case EVENTNumTransmissionRateLimitReleased:
+		m.logger.Info("Transmission rate limit released")
+		m.setTransmitLimited(false)
+	}
+}
+
+func (m *MB_RL7023_11) setTransmitLimited(limited bool) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	if limited == m.tx.limited {
+		return
+	}
+	m.tx.limited = limited
+	if limited {
+		m.tx.released = make(chan struct{})
+	} else {
+		close(m.tx.released)
+	}
+}
+
+// 送信時間の制限中かを返します。モジュールからの通知で更新されるので、コマンドは実行しません。
+func (m *MB_RL7023_11) TransmitLimited() bool {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	return m.tx.limited
+}
+
+// 送信時間の制限が解除されるまで待ちます。ctx が終了した場合は ErrTransmitLimited を返します。
+func (m *MB_RL7023_11) waitTransmit(ctx context.Context) error {
+	m.txMu.Lock()
+	limited, released := m.tx.limited, m.tx.released
+	m.txMu.Unlock()
+	if !limited {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", ErrTransmitLimited, ctx.Err())
+	case <-released:
+		return nil
+	}
+}
+
+// 送信中に制限された場合、タイムアウトの代わりに ErrTransmitLimited を返します。
+func (m *MB_RL7023_11) transmitError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) && m.TransmitLimited() {
+		return fmt.Errorf("%w: %w", ErrTransmitLimited, err)
+	}
+	return err
+}
+
+// 積算送信時間 (S FD) を読み出し、直近 1 時間の送信時間を返します。
+// 読み出した時点の積算送信時間の差から求めるので、1 時間以上前の記録が無い場合は最も古い記録からの送信時間になります。
+func (m *MB_RL7023_11) TransmitUsage(ctx context.Context) (TransmitUsage, error) {
+	total, err := m.TransmissionTime(ctx)
+	if err != nil {
+		return TransmitUsage{}, err
+	}
+	now := time.Now()
+
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	samples := m.tx.samples
+	if len(samples) > 0 && samples[len(samples)-1].total > total {
+		// モジュールのリセットなどで積算送信時間が戻った
+		samples = nil
+	}
+	samples = append(samples, transmitSample{at: now, total: total})
+
+	// 1 時間より前の記録は、1 時間前の時点の値として最も新しいものだけ残す
+	i := 0
+	for i+1 < len(samples) && now.Sub(samples[i+1].at) >= transmitTimeWindow {
+		i++
+	}
+	samples = samples[i:]
+	m.tx.samples = samples
+
+	used := total - samples[0].total
+	return TransmitUsage{
+		Limited:   m.tx.limited,
+		Used:      used,
+		Remaining: max(TransmitTimeBudget-used, 0),
+	}, nil
+}
@@ -1,10 +1,12 @@
 package MB_RL7023_11
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // https://rabbit-note.com/wp-content/uploads/2016/12/50f67559796399098e50cba8fdbe6d0a.pdf
@@ -58,18 +60,119 @@ var (
 	ErrUnknownErrorCode = errors.New("unknown error code")
 )
 
+// exec が返した FAIL ERxx を ErrorCode にして CommandError に記録します。
 func parseError(res []string, err error) error {
 	if err == nil {
 		return nil
 	}
-	if !errors.Is(err, ErrExecFailed) {
+	var ce *CommandError
+	if !errors.As(err, &ce) || !errors.Is(ce.Err, ErrExecFailed) {
 		return err
 	}
 	errCode, err := errorCodeFromString(res[0])
 	if err != nil {
-		return err
+		ce.Err = err
+		return ce
+	}
+	ce.Code = errCode
+	ce.Err = errCode.Error()
+	return ce
+}
+
+// コマンドの実行に失敗したときのエラー
+type CommandError struct {
+	// 実行したコマンド、パスワードなどは伏せてある
+	Command string
+	// FAIL ERxx のエラーコード、FAIL 以外で失敗した場合は 0
+	Code ErrorCode
+	// エコーバックより後に受信した行、エコーバックが無い場合は受信したすべての行
+	Output []string
+	// コマンドを送ってから失敗するまでの時間
+	Elapsed time.Duration
+	// Code に対応する ErrInvalidParameter などのエラー、または通信路のエラー
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("%s: %s %s", e.Command, e.Code, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// FAIL を受信した場合は ErrExecFailed としても扱います。
+func (e *CommandError) Is(target error) bool {
+	return target == ErrExecFailed && e.Code != 0
+}
+
+// 同じコマンドをやり直せば成功する可能性があるかを返します。
+// 未対応のコマンドや引数の誤り (ER04、ER05、ER06) と、呼び出し元による取り消しは false です。
+// ポートの再接続中などの通信路のエラーは、開き直せば成功するので true です。
+func (e *CommandError) Retryable() bool {
+	switch e.Code {
+	case ErrorCodeCommandNotSupported, ErrorCodeInvalidParameterLengh, ErrorCodeInvalidParameter:
+		return false
+	case 0:
+		return !errors.Is(e.Err, context.Canceled)
+	default:
+		return true
+	}
+}
+
+// err がやり直せば成功する可能性のあるエラーかを返します。CommandError 以外では送信時間の制限とタイムアウトが該当します。
+func Retryable(err error) bool {
+	var ce *CommandError
+	if errors.As(err, &ce) {
+		return ce.Retryable()
+	}
+	return errors.Is(err, ErrTransmitLimited) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// 引数に秘密の値を含むコマンド
+var secretArgs = map[string]int{
+	// SKSETPWD <LEN> <PWD>
+	"SKSETPWD": 2,
+	// SKSETRBID <ID>
+	"SKSETRBID": 1,
+	// SKSETPSK <LEN> <KEY>
+	"SKSETPSK": 2,
+	// SKSETKEY <INDEX> <KEY>
+	"SKSETKEY": 2,
+}
+
+// エラーに残すため、コマンドの秘密の値を伏せます。
+func redactCommand(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return command
+	}
+	if i, ok := secretArgs[fields[0]]; ok && i < len(fields) {
+		fields[i] = "****"
+	}
+	return strings.Join(fields, " ")
+}
+
+// 秘密の値を含むコマンドの行であれば、その値を伏せた行を返します。それ以外の行はそのまま返します。
+// エラーやキャプチャーファイルに残すエコーバックに使います。
+func RedactLine(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || secretArgs[fields[0]] == 0 {
+		return line
+	}
+	return redactCommand(line)
+}
+
+// エラーに残すため、受信した行のうちエコーバックされた秘密の値を伏せます。
+func redactOutput(lines []string) []string {
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		out[i] = RedactLine(line)
 	}
-	return errCode.Error()
+	return out
 }
 
 const errorCodePrefix = "FAIL ER"
@@ -0,0 +1,130 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestCommandErrorRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", context.DeadlineExceeded, true},
+		{"echoback mismatch", ErrEchobackMismatch, true},
+		{"port closed", io.ErrClosedPipe, true},
+		{"transport", errors.New("not streaming"), true},
+		{"eof", io.EOF, true},
+		{"canceled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := error(&CommandError{Command: "SKJOIN", Err: tt.err})
+			if got := Retryable(err); got != tt.want {
+				t.Errorf("Retryable(%v) = %v, want %v", err, got, tt.want)
+			}
+			// 呼び出し元でラップされても同じ
+			if got := Retryable(fmt.Errorf("SKJOIN: %w", err)); got != tt.want {
+				t.Errorf("Retryable(wrapped %v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrorRetryable(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+		is   error
+	}{
+		{"FAIL ER04", false, ErrCommandNotSupported},
+		{"FAIL ER05", false, ErrInvalidParameterLengh},
+		{"FAIL ER06", false, ErrInvalidParameter},
+		{"FAIL ER09", true, ErrUARTInputError},
+		{"FAIL ER10", true, ErrCommandFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			err := parseError([]string{tt.line}, &CommandError{Command: "SKSETRBID ****", Err: ErrExecFailed})
+			if !errors.Is(err, tt.is) || !errors.Is(err, ErrExecFailed) {
+				t.Errorf("parseError(%q) = %v, want %v", tt.line, err, tt.is)
+			}
+			if got := Retryable(err); got != tt.want {
+				t.Errorf("Retryable(%v) = %v, want %v", err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	tests := map[string]string{
+		"SKSETPWD C secretsecret":        "SKSETPWD C ****",
+		"SKSETRBID 0011":                 "SKSETRBID ****",
+		"SKSETPSK 20 0123456789ABCDEF":   "SKSETPSK 20 ****",
+		"SKSETKEY 1 0123456789ABCDEF":    "SKSETKEY 1 ****",
+		"SKSENDTO 1 FE80 0E1A 1 0 0005 ": "SKSENDTO 1 FE80 0E1A 1 0 0005",
+	}
+	for in, want := range tests {
+		if got := redactCommand(in); got != want {
+			t.Errorf("redactCommand(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// 失敗したコマンドの Output に残るエコーバックからも秘密の値を伏せる
+func TestCommandErrorOutputRedacted(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		res     []string
+		err     error
+		secret  string
+	}{
+		{"timeout", "SKSETPWD C secretsecret", []string{"SKSETPWD C secretsecret"}, context.DeadlineExceeded, "secretsecret"},
+		// 化けたエコーバックにも ID のほとんどが残る
+		{"echoback mismatch", "SKSETRBID 00112233445566778899AABBCCDDEEFF", []string{"SKSETRBID 00112233445566778899AABBCCDDEEFE", "OK"}, nil, "00112233445566778899AABBCCDDEE"},
+		{"psk", "SKSETPSK 20 0123456789ABCDEF0123456789ABCDEF", []string{"SKSETPSK 20 0123456789ABCDEF0123456789ABCDEF"}, context.DeadlineExceeded, "0123456789ABCDEF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := New(Config{
+				Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+				Transport: &cannedTransport{res: tt.res, err: tt.err},
+			})
+			_, _, err := m.exec(context.Background(), tt.command)
+
+			var ce *CommandError
+			if !errors.As(err, &ce) {
+				t.Fatalf("exec = %v, want CommandError", err)
+			}
+			if strings.Contains(ce.Error(), tt.secret) || strings.Contains(strings.Join(ce.Output, "\n"), tt.secret) {
+				t.Errorf("secret leaked: command %q, output %q", ce.Command, ce.Output)
+			}
+			if len(ce.Output) != len(tt.res) {
+				t.Errorf("Output = %q, want %d lines", ce.Output, len(tt.res))
+			}
+		})
+	}
+}
+
+// 常に同じ応答を返す Transport
+type cannedTransport struct {
+	res []string
+	err error
+}
+
+func (t *cannedTransport) Write(b []uint8) (int, error) {
+	return len(b), nil
+}
+
+func (t *cannedTransport) Exec(ctx context.Context, command []uint8, stopper func(l []string) bool) ([]string, error) {
+	return t.res, t.err
+}
+
+func (t *cannedTransport) AddListner(l *func(lines []string) error) {}
@@ -1,6 +1,7 @@
 package MB_RL7023_11
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"slices"
@@ -73,21 +74,51 @@ type ERXUDP struct {
 	Rport     uint16
 	Lport     uint16
 	SenderLLA string
-	Secured   bool
-	Reserved  uint8
-	Data      []uint8
+	// 受信時の RSSI (dBm)、Dialect.ERXUDPRSSI の場合のみ
+	RSSI    int8
+	Secured bool
+	// サイド、Dialect.ERXUDPSide の場合のみ
+	Reserved uint8
+	Data     []uint8
 }
 
 func NewERXUDP(line string) (*ERXUDP, error) {
+	return DefaultDialect.NewERXUDP(line)
+}
+
+func newERXUDP(line string, d Dialect) (*ERXUDP, error) {
 	if !strings.HasPrefix(line, ERXUDP_ID) {
 		return nil, ErrInvalidEventID
 	}
 
-	fields := strings.Fields(line[len(ERXUDP_ID+" "):])
-	if len(fields) != 9 {
+	n := 8
+	if d.ERXUDPRSSI {
+		n++
+	}
+	if d.ERXUDPSide {
+		n++
+	}
+	// バイナリ表示のデータ部は空白を含むことがあるので、データ部は分割しない
+	fields := strings.SplitN(line[len(ERXUDP_ID+" "):], " ", n)
+	if len(fields) != n {
 		return nil, ErrInvalidEventFormat
 	}
 
+	var rssi int8
+	if d.ERXUDPRSSI {
+		v, err := strconv.ParseUint(fields[5], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		rssi = int8(v)
+		// RSSI を取り除き、以降は他のモジュールと同じ位置で扱う
+		fields = slices.Delete(fields, 5, 6)
+	}
+	if !d.ERXUDPSide {
+		// サイドの位置を 0 で埋め、以降は他のモジュールと同じ位置で扱う
+		fields = slices.Insert(fields, 6, "0")
+	}
+
 	rport, err := strconv.ParseUint(fields[2], 16, 16)
 	if err != nil {
 		return nil, err
@@ -104,13 +135,13 @@ func NewERXUDP(line string) (*ERXUDP, error) {
 	if err != nil {
 		return nil, err
 	}
-	data := make([]uint8, len(fields[8])/2)
-	for i := 0; i < len(fields[8]); i += 2 {
-		b, err := strconv.ParseUint(fields[8][i:i+2], 16, 8)
-		if err != nil {
-			return nil, err
-		}
-		data[i/2] = uint8(b)
+	length, err := strconv.ParseUint(fields[7], 16, 16)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parsePayload(fields[8], int(length))
+	if err != nil {
+		return nil, err
 	}
 
 	return &ERXUDP{
@@ -119,18 +150,31 @@ func NewERXUDP(line string) (*ERXUDP, error) {
 		Rport:     uint16(rport),
 		Lport:     uint16(lport),
 		SenderLLA: fields[4],
+		RSSI:      rssi,
 		Secured:   secured,
 		Reserved:  uint8(reserved),
 		Data:      data,
 	}, nil
 }
 
+// データ部を取り出します。長さがデータ長と同じならバイナリ表示、2 倍なら 16 進 ASCII 表示として扱います。
+func parsePayload(s string, length int) ([]uint8, error) {
+	switch len(s) {
+	case length:
+		return []uint8(s), nil
+	case length * 2:
+		return hex.DecodeString(s)
+	default:
+		return nil, ErrInvalidEventFormat
+	}
+}
+
 // TCP でデータを受信すると通知されます。
 type ERXTCP struct {
 	Sender string
 	Rport  uint16
 	Lport  uint16
-	Data   string
+	Data   []uint8
 }
 
 func NewERXTCP(line string) (*ERXTCP, error) {
@@ -138,8 +182,9 @@ func NewERXTCP(line string) (*ERXTCP, error) {
 		return nil, ErrInvalidEventID
 	}
 
-	fields := strings.Fields(line[len(ERXTCP_ID+" "):])
-	if len(fields) != 4 {
+	// バイナリ表示のデータ部は空白を含むことがあるので、データ部は分割しない
+	fields := strings.SplitN(line[len(ERXTCP_ID+" "):], " ", 5)
+	if len(fields) != 5 {
 		return nil, ErrInvalidEventFormat
 	}
 
@@ -151,12 +196,20 @@ func NewERXTCP(line string) (*ERXTCP, error) {
 	if err != nil {
 		return nil, err
 	}
+	length, err := strconv.ParseUint(fields[3], 16, 16)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parsePayload(fields[4], int(length))
+	if err != nil {
+		return nil, err
+	}
 
 	return &ERXTCP{
 		Sender: fields[0],
 		Rport:  uint16(rport),
 		Lport:  uint16(lport),
-		Data:   fields[3],
+		Data:   data,
 	}, nil
 }
 
@@ -198,8 +251,9 @@ func NewETCP(line string) (*ETCP, error) {
 		return nil, ErrInvalidEventID
 	}
 
+	// 接続確立以外はステータスとハンドルのみ
 	fields := strings.Fields(line[len(ETCP_ID+" "):])
-	if len(fields) != 5 {
+	if len(fields) < 2 {
 		return nil, ErrInvalidEventFormat
 	}
 
@@ -207,16 +261,18 @@ func NewETCP(line string) (*ETCP, error) {
 	if err != nil {
 		return nil, err
 	}
+	handle, err := strconv.ParseUint(fields[1], 16, 8)
+	if err != nil {
+		return nil, err
+	}
 
-	var handle uint64
 	var ipaddr string
 	var rport uint64
 	var lport uint64
 
 	if ETCPStatus(status) == ETCPStatusConnected {
-		handle, err = strconv.ParseUint(fields[1], 16, 8)
-		if err != nil {
-			return nil, err
+		if len(fields) != 5 {
+			return nil, ErrInvalidEventFormat
 		}
 		ipaddr = fields[2]
 		rport, err = strconv.ParseUint(fields[3], 16, 16)
@@ -309,44 +365,75 @@ type EPANDESC struct {
 	PairID      string
 }
 
+// MB-RL7023-11 の書式で EPANDESC を解析します。
 func NewEPANDESC(lines []string) (*EPANDESC, error) {
+	return DefaultDialect.NewEPANDESC(lines)
+}
+
+// "  Channel:21" のようなラベル付きの行から値を取り出します。
+// モジュールによって行の順番やラベルの大文字小文字が異なるので、ラベルで探します。
+// hasSide の場合は Side の行が必要で、そうでない場合は Side を 0 とします。
+func newEPANDESC(lines []string, hasSide bool) (*EPANDESC, error) {
 	if !strings.HasPrefix(lines[0], EPANDESC_ID) {
 		return nil, ErrInvalidEventID
 	}
 
-	if len(lines) != 8 {
-		return nil, ErrInvalidEventFormat
+	values := map[string]string{}
+	for _, line := range lines[1:] {
+		label, val, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			return nil, ErrInvalidEventFormat
+		}
+		values[strings.ToLower(label)] = val
 	}
 
-	channel, err := strconv.ParseUint(lines[1][len("  Channel:"):], 16, 8)
-	if err != nil {
-		return nil, err
+	parse := func(label string, bitSize int, required bool) (uint64, error) {
+		val, ok := values[label]
+		if !ok {
+			if required {
+				return 0, ErrInvalidEventFormat
+			}
+			return 0, nil
+		}
+		return strconv.ParseUint(val, 16, bitSize)
 	}
-	channelPage, err := strconv.ParseUint(lines[2][len("  Channel Page:"):], 16, 8)
+
+	channel, err := parse("channel", 8, true)
 	if err != nil {
 		return nil, err
 	}
-	panID, err := strconv.ParseUint(lines[3][len("  PAN ID:"):], 16, 16)
+	channelPage, err := parse("channel page", 8, false)
 	if err != nil {
 		return nil, err
 	}
-	lqi, err := strconv.ParseUint(lines[5][len("  LQI:"):], 16, 8)
+	panID, err := parse("pan id", 16, true)
 	if err != nil {
 		return nil, err
 	}
-	side, err := strconv.ParseUint(lines[6][len("  Side:"):], 16, 8)
+	lqi, err := parse("lqi", 8, false)
 	if err != nil {
 		return nil, err
 	}
+	var side uint64
+	if hasSide {
+		side, err = parse("side", 8, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	addr, ok := values["addr"]
+	if !ok {
+		return nil, ErrInvalidEventFormat
+	}
 
 	return &EPANDESC{
 		Channel:     uint8(channel),
 		ChannelPage: uint8(channelPage),
 		PanID:       uint16(panID),
-		Addr:        lines[4][len("  Addr:"):],
+		Addr:        addr,
 		LQI:         uint8(lqi),
 		Side:        uint8(side),
-		PairID:      lines[7][len("  PairID:"):],
+		PairID:      values["pairid"],
 	}, nil
 }
 
@@ -360,11 +447,20 @@ type EEDSCANResult struct {
 	RSSI    uint8
 }
 
+// RSSI を dBm に換算します。換算式は LQI と同じです。
+func (r EEDSCANResult) DBm() float64 {
+	return 0.275*float64(r.RSSI) - 104.27
+}
+
 func NewEEDSCAN(lines []string) (*EEDSCAN, error) {
 	if !strings.HasPrefix(lines[0], EEDSCAN_ID) {
 		return nil, ErrInvalidEventID
 	}
 
+	if len(lines) < 2 {
+		return nil, ErrInvalidEventFormat
+	}
+
 	fields := strings.Fields(lines[1])
 	results := make([]EEDSCANResult, len(fields)/2)
 	for i := 0; i < len(fields); i += 2 {
@@ -389,7 +485,9 @@ func NewEEDSCAN(lines []string) (*EEDSCAN, error) {
 }
 
 // UDP または TCP の待ち受けポート設定状態を一覧表示します。
+// ハンドルの番号で引けるよう、未使用のハンドルも 0 として残します。
 type EPORT struct {
+	// UDP[i] は UDP ハンドル i+1 の待ち受けポート
 	UDP []uint16
 	TCP []uint16
 }
@@ -413,10 +511,6 @@ func NewEPORT(lines []string) (*EPORT, error) {
 			return nil, err
 		}
 
-		if port == 0 {
-			continue
-		}
-
 		switch section {
 		case "UDP":
 			udp = append(udp, uint16(port))
@@ -529,6 +623,10 @@ func NewEVENT(line string) (*EVENT, error) {
 		return nil, err
 	}
 
+	param := ""
+	if len(fields) > 2 {
+		param = fields[2]
+	}
 	payload := ""
 	if len(fields) > 3 {
 		payload = fields[3]
@@ -537,7 +635,7 @@ func NewEVENT(line string) (*EVENT, error) {
 	return &EVENT{
 		Num:     EVENTNum(num),
 		Sender:  fields[1],
-		Param:   fields[2],
+		Param:   param,
 		Payload: payload,
 	}, nil
 }
@@ -628,7 +726,25 @@ func NewEAPPVER(line string) (*EAPPVER, error) {
 	}, nil
 }
 
+func isResultLine(s string) bool {
+	return s == "OK" || strings.HasPrefix(s, "FAIL")
+}
+
+// 複数行からなるイベントの行数を返します。end が true を返す行か、l の最後までをイベントとして扱います。
+func eventLength(l []string, end func(s string) bool) int {
+	j := slices.IndexFunc(l[1:], end)
+	if j == -1 {
+		return len(l)
+	}
+	return j + 1
+}
+
+// MB-RL7023-11 の書式で受信した行をイベントに変換します。
 func ParseEvent(l []string) []any {
+	return DefaultDialect.ParseEvent(l)
+}
+
+func parseEvent(l []string, d Dialect) []any {
 	var events []any
 	i := 0
 	for {
@@ -638,7 +754,7 @@ func ParseEvent(l []string) []any {
 
 		switch {
 		case strings.HasPrefix(l[i], ERXUDP_ID):
-			e, err := NewERXUDP(l[i])
+			e, err := d.NewERXUDP(l[i])
 			if err == nil {
 				events = append(events, e)
 			} else {
@@ -672,13 +788,7 @@ func ParseEvent(l []string) []any {
 			continue
 
 		case strings.HasPrefix(l[i], EADDR_ID):
-			j := slices.IndexFunc(l[i:], func(s string) bool {
-				return s == "OK"
-			})
-			if j == -1 {
-				i++
-				continue
-			}
+			j := eventLength(l[i:], isResultLine)
 			e, err := NewEADDR(l[i : i+j])
 			if err == nil {
 				events = append(events, e)
@@ -687,13 +797,7 @@ func ParseEvent(l []string) []any {
 			continue
 
 		case strings.HasPrefix(l[i], ENEIGHBOR_ID):
-			j := slices.IndexFunc(l[i:], func(s string) bool {
-				return s == "OK"
-			})
-			if j == -1 {
-				i++
-				continue
-			}
+			j := eventLength(l[i:], isResultLine)
 			e, err := NewENEIGHBOR(l[i : i+j])
 			if err == nil {
 				events = append(events, e)
@@ -702,14 +806,10 @@ func ParseEvent(l []string) []any {
 			continue
 
 		case strings.HasPrefix(l[i], EPANDESC_ID):
-			j := slices.IndexFunc(l[i:], func(s string) bool {
-				return s == ""
+			j := eventLength(l[i:], func(s string) bool {
+				return !strings.HasPrefix(s, " ")
 			})
-			if j == -1 {
-				i++
-				continue
-			}
-			e, err := NewEPANDESC(l[i : i+j])
+			e, err := d.NewEPANDESC(l[i : i+j])
 			if err == nil {
 				events = append(events, e)
 			}
@@ -717,13 +817,7 @@ func ParseEvent(l []string) []any {
 			continue
 
 		case strings.HasPrefix(l[i], EEDSCAN_ID):
-			j := slices.IndexFunc(l[i:], func(s string) bool {
-				return s == ""
-			})
-			if j == -1 {
-				i++
-				continue
-			}
+			j := min(2, len(l[i:]))
 			e, err := NewEEDSCAN(l[i : i+j])
 			if err == nil {
 				events = append(events, e)
@@ -732,13 +826,7 @@ func ParseEvent(l []string) []any {
 			continue
 
 		case strings.HasPrefix(l[i], EPORT_ID):
-			j := slices.IndexFunc(l[i:], func(s string) bool {
-				return s == "OK"
-			})
-			if j == -1 {
-				i++
-				continue
-			}
+			j := eventLength(l[i:], isResultLine)
 			e, err := NewEPORT(l[i : i+j])
 			if err == nil {
 				events = append(events, e)
@@ -747,13 +835,7 @@ func ParseEvent(l []string) []any {
 			continue
 
 		case strings.HasPrefix(l[i], EHANDLE_ID):
-			j := slices.IndexFunc(l[i:], func(s string) bool {
-				return s == "OK"
-			})
-			if j == -1 {
-				i++
-				continue
-			}
+			j := eventLength(l[i:], isResultLine)
 			e, err := NewEHANDLE(l[i : i+j])
 			if err == nil {
 				events = append(events, e)
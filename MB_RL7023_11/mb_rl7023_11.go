@@ -5,12 +5,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/rokoucha/akizuki-dg-route-b-exporter/serial"
 )
 
 const (
@@ -22,34 +25,91 @@ var (
 	ErrEchobackMismatch  = errors.New("echoback mismatch")
 	ErrExecFailed        = errors.New("exec failed")
 	ErrFailedToConnect   = errors.New("failed to connect")
+	ErrInvalidUARTMode   = errors.New("invalid uart mode")
 	ErrPortUnavaiable    = errors.New("port unavaiable")
+	ErrUDPSendFailed     = errors.New("udp send failed")
 	ErrUnexpectedOutput  = errors.New("unexpected output")
 )
 
+// コマンドを送って応答を受け取る通信路、serial.Serial が実装しています。
+type Transport interface {
+	io.Writer
+	// command を送り、stopper が true を返すまでに受信した行を返します。
+	Exec(ctx context.Context, command []uint8, stopper func(l []string) bool) ([]string, error)
+	// 受信した行を受け取るリスナーを登録します。
+	AddListner(l *func(lines []string) error)
+}
+
+// 通信路を開き直したことを通知できる Transport が実装します。
+type Reopener interface {
+	AddReopenListner(l func())
+}
+
+// ERXUDP のデータ部をバイナリ表示として区切れる Transport が実装します。
+// fields はイベントごとのデータ長までのフィールドの数で、nil の場合は 16 進 ASCII 表示として扱います。
+type BinaryPayloadSetter interface {
+	SetBinaryPayload(fields map[string]int)
+}
+
 type MB_RL7023_11 struct {
-	addrs  []string
-	logger *slog.Logger
-	ports  []uint16
-	serial *serial.Serial
+	logger    *slog.Logger
+	transport Transport
+
+	// addrs と ports を守る
+	tableMu sync.RWMutex
+	// 自端末の IPv6 アドレス
+	addrs []string
+	// UDP ハンドル 1 から 6 の待ち受けポート、未使用のハンドルは 0
+	ports []uint16
+
+	subsMu  sync.RWMutex
+	subs    []subscriber
+	dropped atomic.Uint64
+
+	dialect atomic.Pointer[Dialect]
+	// WOPT の設定
+	opt atomic.Uint32
+
+	txMu sync.Mutex
+	tx   transmitState
+
+	sessionMu sync.Mutex
+	session   Session
 }
 
 type Config struct {
-	Logger *slog.Logger
-	Serial *serial.Serial
+	// default: DefaultDialect
+	Dialect   *Dialect
+	Logger    *slog.Logger
+	Transport Transport
 }
 
 func New(c Config) *MB_RL7023_11 {
-	return &MB_RL7023_11{
-		addrs:  []string{},
-		logger: c.Logger,
-		ports:  []uint16{},
-		serial: c.Serial,
+	m := &MB_RL7023_11{
+		addrs:     []string{},
+		logger:    c.Logger,
+		ports:     []uint16{},
+		transport: c.Transport,
+	}
+	if c.Dialect != nil {
+		m.dialect.Store(c.Dialect)
+	} else {
+		m.dialect.Store(&DefaultDialect)
+	}
+	m.opt.Store(uint32(WOPTModeASCII))
+
+	listener := m.listener
+	m.transport.AddListner(&listener)
+	if r, ok := m.transport.(Reopener); ok {
+		r.AddReopenListner(m.reset)
 	}
+
+	return m
 }
 
 func (m *MB_RL7023_11) Initialize(ctx context.Context) error {
 	var err error
-	_, err = m.serial.Write([]uint8("\r\n"))
+	_, err = m.transport.Write([]uint8("\r\n"))
 	if err != nil {
 		return err
 	}
@@ -58,6 +118,25 @@ func (m *MB_RL7023_11) Initialize(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	m.transitSession(nil, SessionStateIdle)
+
+	opt, err := m.ROPT(ctx)
+	switch {
+	case errors.Is(err, ErrCommandNotSupported):
+		// ROPT が無いファームウェアは 16 進 ASCII 表示のみ
+		opt = WOPTModeASCII
+	case err != nil:
+		return err
+	}
+	m.setPayloadMode(opt)
+
+	// 送信時間の制限中に再起動した場合、解除の EVENT 33 しか届かない
+	limited, err := m.TransmissionRateLimitExceeded(ctx)
+	if err != nil {
+		m.logger.Warn("Failed to read transmission rate limit", "error", err)
+	} else {
+		m.setTransmitLimited(limited)
+	}
 
 	res, err := m.SKTABLE(ctx, SKTABLEModeAvailableIPAddresses)
 	if err != nil {
@@ -67,8 +146,10 @@ func (m *MB_RL7023_11) Initialize(ctx context.Context) error {
 	if !ok {
 		return ErrAddressUnavaiable
 	}
+	m.tableMu.Lock()
 	m.addrs = eaddr.IPAddr
-	if len(m.addrs) == 0 {
+	m.tableMu.Unlock()
+	if len(eaddr.IPAddr) == 0 {
 		return ErrAddressUnavaiable
 	}
 
@@ -80,8 +161,10 @@ func (m *MB_RL7023_11) Initialize(ctx context.Context) error {
 	if !ok {
 		return ErrPortUnavaiable
 	}
+	m.tableMu.Lock()
 	m.ports = eport.UDP
-	if len(m.ports) == 0 {
+	m.tableMu.Unlock()
+	if !slices.ContainsFunc(eport.UDP, func(p uint16) bool { return p != 0 }) {
 		return ErrPortUnavaiable
 	}
 
@@ -99,9 +182,11 @@ func startWithStopper(targets []string) func(l []string) bool {
 }
 
 type execOptions struct {
-	Payload []uint8
-	Stopper func(l []string) bool
-	Timeout time.Duration
+	// OK の行も出力に含める、RUART のように OK の後に値を返すコマンド用
+	KeepResult bool
+	Payload    []uint8
+	Stopper    func(l []string) bool
+	Timeout    time.Duration
 }
 
 func (m *MB_RL7023_11) exec(ctx context.Context, command string, options ...execOptions) ([]string, []any, error) {
@@ -130,23 +215,31 @@ func (m *MB_RL7023_11) exec(ctx context.Context, command string, options ...exec
 		if o.Stopper != nil {
 			return o.Stopper(l)
 		}
-		return len(l) == 1 && l[0] == ""
+		return slices.ContainsFunc(l, func(s string) bool { return s == "OK" || strings.HasPrefix(s, "OK ") })
 	}
 
-	res, err := m.serial.Exec(ctx, cmd, stopper)
+	start := time.Now()
+	res, err := m.transport.Exec(ctx, cmd, stopper)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &CommandError{Command: redactCommand(command), Output: redactOutput(res), Elapsed: time.Since(start), Err: err}
 	}
 
-	echobackLine := slices.Index(res, command)
+	// データを伴うコマンドのエコーバックにはデータが続くことがあるので、前方一致で探す
+	echobackLine := slices.IndexFunc(res, func(line string) bool {
+		if o.Payload != nil {
+			return strings.HasPrefix(line, command)
+		}
+		return line == command
+	})
 	if echobackLine == -1 {
-		return nil, nil, ErrEchobackMismatch
+		return nil, nil, &CommandError{Command: redactCommand(command), Output: redactOutput(res), Elapsed: time.Since(start), Err: ErrEchobackMismatch}
 	}
 	linebase := echobackLine + 1
 
 	failLine := slices.IndexFunc(res[linebase:], func(line string) bool { return strings.HasPrefix(line, "FAIL") })
 	if failLine != -1 {
-		return []string{res[failLine+linebase]}, nil, ErrExecFailed
+		// parseError が ErrorCode を記録する
+		return []string{res[failLine+linebase]}, nil, &CommandError{Command: redactCommand(command), Output: res[linebase:], Elapsed: time.Since(start), Err: ErrExecFailed}
 	}
 
 	okLine := slices.IndexFunc(res[linebase:], func(line string) bool { return strings.HasPrefix(line, "OK") })
@@ -156,9 +249,12 @@ func (m *MB_RL7023_11) exec(ctx context.Context, command string, options ...exec
 		okLine += linebase
 	}
 
+	if o.KeepResult && okLine < len(res) {
+		okLine++
+	}
 	output := res[linebase:okLine]
 
-	events := ParseEvent(res[linebase:])
+	events := m.Dialect().ParseEvent(res[linebase:])
 
 	//fmt.Printf("res: %#v, echobackLine: %d, failLine: %d, okLine: %d, output: %#v, events: %#v\n", res, echobackLine, failLine, okLine, output, events)
 
@@ -213,36 +309,27 @@ func (m *MB_RL7023_11) SKSTART(ctx context.Context) error {
 
 // 指定した<IPADDR>に対して PaC（PANA 認証クライアント）として PANA 接続シーケンスを開始します。
 func (m *MB_RL7023_11) SKJOIN(ctx context.Context, ipaddr string) error {
-	stopper := startWithStopper([]string{
-		EVENTNumPANAConnectionFailed.String(),
-		EVENTNumPANAConnected.String(),
-	})
-	res, events, err := m.exec(ctx, "SKJOIN "+ipaddr, execOptions{Stopper: stopper, Timeout: execTimeout * 2 * time.Second})
-	if err != nil {
-		return parseError(res, err)
-	}
-
-	for _, v := range events {
-		if e, ok := v.(*EVENT); ok {
-			if e.Num == EVENTNumPANAConnectionFailed {
-				return ErrFailedToConnect
-			}
-			if e.Num == EVENTNumPANAConnected {
-				return nil
-			}
-		}
-	}
-
-	return ErrUnexpectedOutput
+	m.beginSession(SessionStateJoining, ipaddr)
+	err := m.join(ctx, "SKJOIN "+ipaddr)
+	m.endSession(ctx, err)
+	return err
 }
 
 // 現在接続中の相手に対して再認証シーケンスを開始します。
 func (m *MB_RL7023_11) SKREJOIN(ctx context.Context) error {
+	m.beginSession(SessionStateReauthenticating, "")
+	err := m.join(ctx, "SKREJOIN")
+	m.endSession(ctx, err)
+	return err
+}
+
+// EVENT 24 か 25 を受信するまで待ちます。
+func (m *MB_RL7023_11) join(ctx context.Context, command string) error {
 	stopper := startWithStopper([]string{
 		EVENTNumPANAConnectionFailed.String(),
 		EVENTNumPANAConnected.String(),
 	})
-	res, events, err := m.exec(ctx, "SKREJOIN", execOptions{Stopper: stopper, Timeout: execTimeout * 2 * time.Second})
+	res, events, err := m.exec(ctx, command, execOptions{Stopper: stopper, Timeout: execTimeout * 2 * time.Second})
 	if err != nil {
 		return parseError(res, err)
 	}
@@ -262,7 +349,10 @@ func (m *MB_RL7023_11) SKREJOIN(ctx context.Context) error {
 }
 
 // 現在確立している PANA セッションの終了を要請します。
+// 応答を待てずに失敗した場合も、再び SKTERM しないようセッションは Closed にします。
 func (m *MB_RL7023_11) SKTERM(ctx context.Context) error {
+	defer m.transitSession(nil, SessionStateClosed, SessionStateJoining, SessionStateReauthenticating, SessionStateConnected)
+
 	stopper := startWithStopper([]string{
 		EVENTNumPANASessionClosed.String(),
 		EVENTNumPANASessionCloseResponseTimeout.String(),
@@ -312,8 +402,8 @@ func (m *MB_RL7023_11) erxudpMatcher(received *ERXUDP, handle uint8, ipaddr stri
 	}
 
 	// receiver mismatch
-	if !slices.Contains(m.addrs, received.Dest) {
-		m.logger.Debug("receiver mismatch", "expected", m.addrs, "actual", received.Dest)
+	if addrs := m.localAddrs(); !slices.Contains(addrs, received.Dest) {
+		m.logger.Debug("receiver mismatch", "expected", addrs, "actual", received.Dest)
 		return false
 	}
 
@@ -324,8 +414,8 @@ func (m *MB_RL7023_11) erxudpMatcher(received *ERXUDP, handle uint8, ipaddr stri
 	}
 
 	// destination port mismatch
-	if received.Lport != m.ports[handle-1] {
-		m.logger.Debug("destination port mismatch", "expected", m.ports[handle-1], "actual", received.Lport)
+	if lport, _ := m.port(handle); received.Lport != lport {
+		m.logger.Debug("destination port mismatch", "expected", lport, "actual", received.Lport)
 		return false
 	}
 
@@ -334,29 +424,26 @@ func (m *MB_RL7023_11) erxudpMatcher(received *ERXUDP, handle uint8, ipaddr stri
 
 // 指定した宛先に UDP でデータを送信します。
 func (m *MB_RL7023_11) SKSENDTO(ctx context.Context, handle uint8, ipaddr string, port uint16, sec SKSENDTOSec, reserved SKSENDTOReserved, payload []uint8) (*ERXUDP, error) {
-	if len(m.addrs) == 0 {
+	if len(m.localAddrs()) == 0 {
 		return nil, ErrAddressUnavaiable
 	}
 
-	if len(m.ports) == 0 || len(m.ports) < int(handle) {
+	if p, _ := m.port(handle); p == 0 {
 		return nil, ErrPortUnavaiable
 	}
 
-	command := fmt.Sprintf(
-		"SKSENDTO %X %s %04X %X %X %04X ",
-		handle,
-		ipaddr,
-		port,
-		sec,
-		reserved,
-		len(payload),
-	)
+	err := m.waitTransmit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	command := m.Dialect().sendtoCommand(handle, ipaddr, port, sec, reserved, payload)
 	stopper := func(l []string) bool {
 		return slices.ContainsFunc(l, func(s string) bool {
 			if !strings.HasPrefix(s, ERXUDP_ID) {
 				return false
 			}
-			e, err := NewERXUDP(s)
+			e, err := m.Dialect().NewERXUDP(s)
 			if err != nil {
 				return false
 			}
@@ -366,7 +453,7 @@ func (m *MB_RL7023_11) SKSENDTO(ctx context.Context, handle uint8, ipaddr string
 	}
 	res, events, err := m.exec(ctx, command, execOptions{Payload: payload, Stopper: stopper, Timeout: execTimeout * 2 * time.Second})
 	if err != nil {
-		return nil, parseError(res, err)
+		return nil, m.transmitError(parseError(res, err))
 	}
 
 	for _, v := range events {
@@ -380,32 +467,135 @@ func (m *MB_RL7023_11) SKSENDTO(ctx context.Context, handle uint8, ipaddr string
 	return nil, ErrUnexpectedOutput
 }
 
+// 指定した宛先に UDP でデータを送信し、送信完了の EVENT 21 を待ちます。SKSENDTO と異なり応答は待ちません。
+func (m *MB_RL7023_11) SKSENDTONoReply(ctx context.Context, handle uint8, ipaddr string, port uint16, sec SKSENDTOSec, reserved SKSENDTOReserved, payload []uint8) error {
+	isSent := func(s string) bool {
+		if !strings.HasPrefix(s, EVENT_ID) {
+			return false
+		}
+		e, err := NewEVENT(s)
+		return err == nil && e.Num == EVENTNumUDPSent
+	}
+
+	// OK と EVENT 21 の順番はモジュールによって異なるので、両方を受信するまで待つ
+	accepted, sent := false, false
+	stopper := func(l []string) bool {
+		accepted = accepted || slices.Contains(l, "OK")
+		sent = sent || slices.ContainsFunc(l, isSent)
+		return accepted && sent
+	}
+
+	err := m.waitTransmit(ctx)
+	if err != nil {
+		return err
+	}
+
+	command := m.Dialect().sendtoCommand(handle, ipaddr, port, sec, reserved, payload)
+	res, events, err := m.exec(ctx, command, execOptions{Payload: payload, Stopper: stopper})
+	if err != nil {
+		return m.transmitError(parseError(res, err))
+	}
+
+	for _, v := range events {
+		if e, ok := v.(*EVENT); ok && e.Num == EVENTNumUDPSent {
+			// 00: 成功、01: 失敗、02: アドレス要請を行ったため送信されていない
+			if e.Param != "00" {
+				return ErrUDPSendFailed
+			}
+			return nil
+		}
+	}
+
+	return ErrUnexpectedOutput
+}
+
+// match が true を返す ETCP を受信するまで待つ stopper を返します。
+func etcpStopper(match func(e *ETCP) bool) func(l []string) bool {
+	return func(l []string) bool {
+		return slices.ContainsFunc(l, func(s string) bool {
+			if !strings.HasPrefix(s, ETCP_ID) {
+				return false
+			}
+			e, err := NewETCP(s)
+			if err != nil {
+				return false
+			}
+
+			return match(e)
+		})
+	}
+}
+
+func findETCP(events []any, match func(e *ETCP) bool) (*ETCP, bool) {
+	for _, v := range events {
+		if e, ok := v.(*ETCP); ok && match(e) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
 // 指定した宛先に TCP の接続要求を発行します。
+// 接続できなかった場合は受信した ETCP と ErrFailedToConnect を返します。
 func (m *MB_RL7023_11) SKCONNECT(ctx context.Context, ipaddr string, rport uint16, lport uint16) (*ETCP, error) {
-	panic("not supported")
+	match := func(e *ETCP) bool {
+		if e.Status == ETCPStatusConnected {
+			return e.IPAddr == ipaddr && e.Rport == rport && e.Lport == lport
+		}
+		return e.Status == ETCPStatusClosed || e.Status == ETCPStatusSourcePortAlreadyUsed
+	}
+
+	command := fmt.Sprintf("SKCONNECT %s %04X %04X", ipaddr, rport, lport)
+	res, events, err := m.exec(ctx, command, execOptions{Stopper: etcpStopper(match), Timeout: execTimeout * 2 * time.Second})
+	if err != nil {
+		return nil, parseError(res, err)
+	}
+
+	e, ok := findETCP(events, match)
+	if !ok {
+		return nil, ErrUnexpectedOutput
+	}
+	if e.Status != ETCPStatusConnected {
+		return e, ErrFailedToConnect
+	}
+
+	return e, nil
 }
 
 // 指定したハンドル番号に対応する TCP コネクションを介して接続相手にデータを送信します。
+// 送信前に切断された場合は Status が ETCPStatusClosed の ETCP を返します。
 func (m *MB_RL7023_11) SKSEND(ctx context.Context, handle uint8, data []uint8) (*ETCP, error) {
-	stopper := startWithStopper([]string{ETCP_ID})
+	match := func(e *ETCP) bool {
+		return e.Handle == handle && (e.Status == ETCPStatusSent || e.Status == ETCPStatusClosed)
+	}
+
+	err := m.waitTransmit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	command := fmt.Sprintf("SKSEND %X %04X ", handle, len(data))
-	res, events, err := m.exec(ctx, command, execOptions{Payload: data, Stopper: stopper, Timeout: execTimeout * 2 * time.Second})
+	res, events, err := m.exec(ctx, command, execOptions{Payload: data, Stopper: etcpStopper(match), Timeout: execTimeout * 2 * time.Second})
 	if err != nil {
-		return nil, parseError(res, err)
+		return nil, m.transmitError(parseError(res, err))
 	}
 
-	for _, v := range events {
-		if e, ok := v.(*ETCP); ok {
-			return e, nil
-		}
+	e, ok := findETCP(events, match)
+	if !ok {
+		return nil, ErrUnexpectedOutput
 	}
 
-	return nil, ErrUnexpectedOutput
+	return e, nil
 }
 
 // 指定したハンドルに対応する TCP コネクションの切断要求を発行します。
 func (m *MB_RL7023_11) SKCLOSE(ctx context.Context, handle uint8) error {
-	panic("not supported")
+	match := func(e *ETCP) bool {
+		return e.Handle == handle && e.Status == ETCPStatusClosed
+	}
+
+	res, _, err := m.exec(ctx, fmt.Sprintf("SKCLOSE %X", handle), execOptions{Stopper: etcpStopper(match), Timeout: execTimeout * 2 * time.Second})
+	return parseError(res, err)
 }
 
 type SKPINGReserved uint8
@@ -436,7 +626,7 @@ const SKSCANReservedValue SKSCANReserved = 0x00
 
 // 指定したチャンネルに対してアクティブスキャンまたは ED スキャンを実行します。
 func (m *MB_RL7023_11) SKSCAN(ctx context.Context, mode SKSCANMode, channelMask uint32, duration uint8, reserved SKSCANReserved) ([]any, error) {
-	command := fmt.Sprintf("SKSCAN %X %08X %X %X", mode, channelMask, duration, reserved)
+	command := m.Dialect().scanCommand(mode, channelMask, duration, reserved)
 	stopper := startWithStopper([]string{
 		EVENTNumActiveScanned.String(),
 		EEDSCAN_ID,
@@ -643,26 +833,183 @@ func (m *MB_RL7023_11) SKRFLO(ctx context.Context, mode uint8) error {
 
 // MAC アドレス(64bit)から IPv6 リンクローカルアドレスへ変換した結果を表示します。
 func (m *MB_RL7023_11) SKLL64(ctx context.Context, addr64 string) (string, error) {
-	res, _, err := m.exec(ctx, "SKLL64 "+addr64)
-	return res[0], parseError(res, err)
+	command := "SKLL64 " + addr64
+	// OK を返さないので、エコーバック以外の行が来たら終わる
+	stopper := func(l []string) bool {
+		return slices.ContainsFunc(l, func(s string) bool { return s != command && net.ParseIP(s) != nil })
+	}
+	res, _, err := m.exec(ctx, command, execOptions{Stopper: stopper})
+	if err != nil {
+		return "", parseError(res, err)
+	}
+	i := slices.IndexFunc(res, func(s string) bool { return net.ParseIP(s) != nil })
+	if i == -1 {
+		return "", ErrUnexpectedOutput
+	}
+	return res[i], nil
 }
 
-// (not supported) ERXUDP、ERXTCP のデータ部の表示形式を設定します。
-func (m *MB_RL7023_11) WOPT(mctx context.Context, ode uint8) error {
-	panic("not supported")
+// ERXUDP、ERXTCP のデータ部の表示形式
+type WOPTMode uint8
+
+const (
+	// バイナリ表示
+	WOPTModeBinary WOPTMode = 0x00
+	// 16 進 ASCII 表示
+	WOPTModeASCII WOPTMode = 0x01
+)
+
+// ERXUDP、ERXTCP のデータ部の表示形式を設定します。
+func (m *MB_RL7023_11) WOPT(ctx context.Context, mode WOPTMode) error {
+	if mode > WOPTModeASCII {
+		return ErrInvalidParameter
+	}
+
+	res, _, err := m.exec(ctx, fmt.Sprintf("WOPT %02X", uint8(mode)))
+	if err != nil {
+		return parseError(res, err)
+	}
+
+	m.setPayloadMode(mode)
+	return nil
 }
 
-// (not supported) WOPT コマンドの設定状態を表示します。
-func (m *MB_RL7023_11) ROPT(ctx context.Context) (uint8, error) {
-	panic("not supported")
+// WOPT コマンドの設定状態を表示します。
+func (m *MB_RL7023_11) ROPT(ctx context.Context) (WOPTMode, error) {
+	res, _, err := m.exec(ctx, "ROPT", execOptions{KeepResult: true})
+	if err != nil {
+		return 0, parseError(res, err)
+	}
+
+	v, err := parseResultValue(res)
+	if err != nil {
+		return 0, err
+	}
+	return WOPTMode(v), nil
 }
 
-// (not supported) UART 設定（ボーレート、キャラクター間インターバル、フロー制御）を設定します。
-func (m *MB_RL7023_11) WUART(ctx context.Context, mode uint8) error {
-	panic("not supported")
+// 受信したデータ部を表示形式に合わせて区切るよう Transport に伝えます。
+func (m *MB_RL7023_11) setPayloadMode(mode WOPTMode) {
+	m.opt.Store(uint32(mode))
+
+	setter, ok := m.transport.(BinaryPayloadSetter)
+	if !ok {
+		if mode == WOPTModeBinary {
+			m.logger.Warn("Transport does not support binary payload, ERXUDP containing CRLF may be split")
+		}
+		return
+	}
+	if mode != WOPTModeBinary {
+		setter.SetBinaryPayload(nil)
+		return
+	}
+	setter.SetBinaryPayload(m.Dialect().binaryFields())
 }
 
-// (not supported) WUART コマンドの設定状態を表示します。
-func (m *MB_RL7023_11) RUART(ctx context.Context) (uint8, error) {
-	panic("not supported")
+// UART 設定
+// bit 0-2: ボーレート、bit 3-5: キャラクター間インターバル、bit 7: フロー制御
+type UARTMode uint8
+
+type UARTInterval uint8
+
+const (
+	// インターバルなし
+	UARTIntervalNone  UARTInterval = 0x00
+	UARTInterval100us UARTInterval = 0x01
+	UARTInterval200us UARTInterval = 0x02
+	UARTInterval300us UARTInterval = 0x03
+	UARTInterval400us UARTInterval = 0x04
+	UARTInterval50us  UARTInterval = 0x05
+)
+
+// bit 7 がフロー制御、bit 6-4 がキャラクター間インターバル、bit 3 は予約、bit 2-0 がボーレート
+const (
+	uartModeBaudRateMask    = 0x07
+	uartModeIntervalShift   = 4
+	uartModeIntervalMask    = 0x07 << uartModeIntervalShift
+	uartModeFlowControlMask = 0x80
+)
+
+// ボーレートの設定値、添字が bit 0-2 の値
+var UARTBaudRates = []int{115200, 2400, 4800, 9600, 19200, 38400, 57600}
+
+func NewUARTMode(baudRate int, interval UARTInterval, flowControl bool) (UARTMode, error) {
+	b := slices.Index(UARTBaudRates, baudRate)
+	if b == -1 {
+		return 0, ErrInvalidUARTMode
+	}
+	if interval > UARTInterval50us {
+		return 0, ErrInvalidUARTMode
+	}
+
+	mode := UARTMode(b) | UARTMode(interval)<<uartModeIntervalShift
+	if flowControl {
+		mode |= uartModeFlowControlMask
+	}
+	return mode, nil
+}
+
+// 未定義の値の場合は 0 を返します。
+func (u UARTMode) BaudRate() int {
+	b := int(u & uartModeBaudRateMask)
+	if b >= len(UARTBaudRates) {
+		return 0
+	}
+	return UARTBaudRates[b]
+}
+
+func (u UARTMode) Interval() UARTInterval {
+	return UARTInterval((u & uartModeIntervalMask) >> uartModeIntervalShift)
+}
+
+func (u UARTMode) FlowControl() bool {
+	return u&uartModeFlowControlMask != 0
+}
+
+// ボーレートだけを変更した設定を返します。
+func (u UARTMode) WithBaudRate(baudRate int) (UARTMode, error) {
+	return NewUARTMode(baudRate, u.Interval(), u.FlowControl())
+}
+
+func (u UARTMode) String() string {
+	return fmt.Sprintf("%02X", uint8(u))
+}
+
+// UART 設定（ボーレート、キャラクター間インターバル、フロー制御）を設定します。
+// 応答は変更前の設定で返り、以降の通信は新しい設定で行われます。ホスト側の設定は SetUART で合わせます。
+func (m *MB_RL7023_11) WUART(ctx context.Context, mode UARTMode) error {
+	if mode.BaudRate() == 0 || mode.Interval() > UARTInterval50us {
+		return ErrInvalidUARTMode
+	}
+
+	res, _, err := m.exec(ctx, "WUART "+mode.String())
+	return parseError(res, err)
+}
+
+// WUART コマンドの設定状態を表示します。
+func (m *MB_RL7023_11) RUART(ctx context.Context) (UARTMode, error) {
+	res, _, err := m.exec(ctx, "RUART", execOptions{KeepResult: true})
+	if err != nil {
+		return 0, parseError(res, err)
+	}
+
+	v, err := parseResultValue(res)
+	if err != nil {
+		return 0, err
+	}
+	return UARTMode(v), nil
+}
+
+// "OK 05" のような結果の行から値を取り出します。
+func parseResultValue(res []string) (uint8, error) {
+	i := slices.IndexFunc(res, func(s string) bool { return strings.HasPrefix(s, "OK ") })
+	if i == -1 {
+		return 0, ErrUnexpectedOutput
+	}
+
+	v, err := strconv.ParseUint(strings.TrimSpace(res[i][len("OK "):]), 16, 8)
+	if err != nil {
+		return 0, ErrUnexpectedOutput
+	}
+	return uint8(v), nil
 }
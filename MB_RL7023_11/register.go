@@ -1,7 +1,22 @@
 package MB_RL7023_11
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var ErrRegisterOutOfRange = errors.New("register value out of range")
+
+const (
+	minChannel             = 0x21
+	maxChannel             = 0x3C
+	pairingIDLength        = 8
+	minPANASessionLifetime = 0x3C * time.Second
+	maxPANASessionLifetime = math.MaxUint32 * time.Second
 )
 
 // 仮想レジスタ
@@ -40,3 +55,193 @@ const (
 func (r Register) String() string {
 	return fmt.Sprintf("S%02X", uint8(r))
 }
+
+// レジスタの値を読み出します。
+func (m *MB_RL7023_11) readRegister(ctx context.Context, r Register) (string, error) {
+	e, err := m.SKSREG(ctx, r, "")
+	if err != nil {
+		return "", err
+	}
+	return e.Val, nil
+}
+
+func (m *MB_RL7023_11) readUint(ctx context.Context, r Register, bitSize int) (uint64, error) {
+	val, err := m.readRegister(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.ParseUint(val, 16, bitSize)
+	if err != nil {
+		return 0, ErrUnexpectedOutput
+	}
+	return v, nil
+}
+
+func (m *MB_RL7023_11) readBool(ctx context.Context, r Register) (bool, error) {
+	val, err := m.readRegister(ctx, r)
+	if err != nil {
+		return false, err
+	}
+
+	switch val {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	default:
+		return false, ErrUnexpectedOutput
+	}
+}
+
+func (m *MB_RL7023_11) writeRegister(ctx context.Context, r Register, val string) error {
+	_, err := m.SKSREG(ctx, r, val)
+	return err
+}
+
+func (m *MB_RL7023_11) writeBool(ctx context.Context, r Register, v bool) error {
+	if v {
+		return m.writeRegister(ctx, r, "1")
+	}
+	return m.writeRegister(ctx, r, "0")
+}
+
+// 自端末が使用する周波数の論理チャンネル番号を返します。
+func (m *MB_RL7023_11) Channel(ctx context.Context) (uint8, error) {
+	v, err := m.readUint(ctx, RegisterChannel, 8)
+	return uint8(v), err
+}
+
+// 自端末が使用する周波数の論理チャンネル番号 (0x21 - 0x3C) を設定します。
+func (m *MB_RL7023_11) SetChannel(ctx context.Context, channel uint8) error {
+	if channel < minChannel || channel > maxChannel {
+		return fmt.Errorf("%w: channel %02X", ErrRegisterOutOfRange, channel)
+	}
+	return m.writeRegister(ctx, RegisterChannel, fmt.Sprintf("%02X", channel))
+}
+
+// 自端末の PAN ID を返します。
+func (m *MB_RL7023_11) PANID(ctx context.Context) (uint16, error) {
+	v, err := m.readUint(ctx, RegisterPANID, 16)
+	return uint16(v), err
+}
+
+// 自端末の PAN ID を設定します。
+func (m *MB_RL7023_11) SetPANID(ctx context.Context, panID uint16) error {
+	return m.writeRegister(ctx, RegisterPANID, fmt.Sprintf("%04X", panID))
+}
+
+// MAC 層セキュリティのフレームカウンタを返します。
+func (m *MB_RL7023_11) FrameCounter(ctx context.Context) (uint32, error) {
+	v, err := m.readUint(ctx, RegisterFrameCounter, 32)
+	return uint32(v), err
+}
+
+// Pairing ID を返します。
+func (m *MB_RL7023_11) PairingID(ctx context.Context) (string, error) {
+	return m.readRegister(ctx, RegisterPairingID)
+}
+
+// Pairing ID (ASCII 8 文字) を設定します。
+func (m *MB_RL7023_11) SetPairingID(ctx context.Context, id string) error {
+	if len(id) != pairingIDLength {
+		return fmt.Errorf("%w: pairing id %q", ErrRegisterOutOfRange, id)
+	}
+	for _, c := range []uint8(id) {
+		if c <= ' ' || c > '~' {
+			return fmt.Errorf("%w: pairing id %q", ErrRegisterOutOfRange, id)
+		}
+	}
+	return m.writeRegister(ctx, RegisterPairingID, id)
+}
+
+// ビーコン要求に応答するかを返します。
+func (m *MB_RL7023_11) RespondBeaconRequest(ctx context.Context) (bool, error) {
+	return m.readBool(ctx, RegisterRespondBeaconRequest)
+}
+
+// ビーコン要求に応答するかを設定します。
+func (m *MB_RL7023_11) SetRespondBeaconRequest(ctx context.Context, v bool) error {
+	return m.writeBool(ctx, RegisterRespondBeaconRequest, v)
+}
+
+// PANA セッションライフタイムを返します。
+func (m *MB_RL7023_11) PANASessionLifetime(ctx context.Context) (time.Duration, error) {
+	v, err := m.readUint(ctx, RegisterPANASessionLifetime, 32)
+	return time.Duration(v) * time.Second, err
+}
+
+// PANA セッションライフタイム (60 秒 - 0xFFFFFFFF 秒、秒単位) を設定します。
+func (m *MB_RL7023_11) SetPANASessionLifetime(ctx context.Context, d time.Duration) error {
+	if d < minPANASessionLifetime || d > maxPANASessionLifetime || d%time.Second != 0 {
+		return fmt.Errorf("%w: pana session lifetime %s", ErrRegisterOutOfRange, d)
+	}
+	return m.writeRegister(ctx, RegisterPANASessionLifetime, fmt.Sprintf("%08X", uint32(d/time.Second)))
+}
+
+// ライフタイムが切れる前に自動で再認証するかを返します。
+func (m *MB_RL7023_11) AutoReAuthentication(ctx context.Context) (bool, error) {
+	return m.readBool(ctx, RegisterAutoReAuthentication)
+}
+
+// ライフタイムが切れる前に自動で再認証するかを設定します。
+func (m *MB_RL7023_11) SetAutoReAuthentication(ctx context.Context, v bool) error {
+	return m.writeBool(ctx, RegisterAutoReAuthentication, v)
+}
+
+// MAC 層ブロードキャストを暗号化するかを返します。
+func (m *MB_RL7023_11) EncryptBroadcastIPPacket(ctx context.Context) (bool, error) {
+	return m.readBool(ctx, RegisterEncryptBroadcastIPPacket)
+}
+
+// MAC 層ブロードキャストを暗号化するかを設定します。
+func (m *MB_RL7023_11) SetEncryptBroadcastIPPacket(ctx context.Context, v bool) error {
+	return m.writeBool(ctx, RegisterEncryptBroadcastIPPacket, v)
+}
+
+// 暗号化されていない ICMP メッセージを受け付けるかを返します。
+func (m *MB_RL7023_11) PlainICMPMessageAccept(ctx context.Context) (bool, error) {
+	return m.readBool(ctx, RegisterPlainICMPMessageAccept)
+}
+
+// 暗号化されていない ICMP メッセージを受け付けるかを設定します。
+func (m *MB_RL7023_11) SetPlainICMPMessageAccept(ctx context.Context, v bool) error {
+	return m.writeBool(ctx, RegisterPlainICMPMessageAccept, v)
+}
+
+// 送信時間の制限中かを返します。
+func (m *MB_RL7023_11) TransmissionRateLimitExceeded(ctx context.Context) (bool, error) {
+	return m.readBool(ctx, RegisterTransmissionRateLimitExceeded)
+}
+
+// 無線送信の積算時間を返します。
+func (m *MB_RL7023_11) TransmissionTime(ctx context.Context) (time.Duration, error) {
+	v, err := m.readUint(ctx, RegisterTransmissionSunTime, 64)
+	if err != nil {
+		return 0, err
+	}
+	if v > math.MaxInt64/uint64(time.Millisecond) {
+		return math.MaxInt64, nil
+	}
+	return time.Duration(v) * time.Millisecond, nil
+}
+
+// 無線送信の積算時間を 0 に戻します。
+func (m *MB_RL7023_11) ResetTransmissionTime(ctx context.Context) error {
+	return m.writeRegister(ctx, RegisterTransmissionSunTime, "0")
+}
+
+// エコーバックが有効かを返します。コマンドの応答はエコーバックを前提にしているので、設定は変更できません。
+func (m *MB_RL7023_11) Echoback(ctx context.Context) (bool, error) {
+	return m.readBool(ctx, RegisterEchoback)
+}
+
+// 起動時に保存した設定を読み込むかを返します。
+func (m *MB_RL7023_11) Autoload(ctx context.Context) (bool, error) {
+	return m.readBool(ctx, RegisterAutoload)
+}
+
+// 起動時に保存した設定を読み込むかを設定します。
+func (m *MB_RL7023_11) SetAutoload(ctx context.Context, v bool) error {
+	return m.writeBool(ctx, RegisterAutoload, v)
+}
@@ -0,0 +1,167 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// PANA セッションの状態
+type SessionState uint8
+
+const (
+	// 参加していない
+	SessionStateIdle SessionState = iota
+	// SKJOIN で接続中
+	SessionStateJoining
+	SessionStateConnected
+	// SKREJOIN または自動再認証で再認証中
+	SessionStateReauthenticating
+	// SKTERM か相手からの要求で終了した
+	SessionStateClosed
+	// 接続や再認証に失敗した、またはライフタイムが切れた
+	SessionStateFailed
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionStateIdle:
+		return "idle"
+	case SessionStateJoining:
+		return "joining"
+	case SessionStateConnected:
+		return "connected"
+	case SessionStateReauthenticating:
+		return "reauthenticating"
+	case SessionStateClosed:
+		return "closed"
+	case SessionStateFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// PANA セッションの状態の変化、SubscribeSession で購読できます。
+type SessionStateChange struct {
+	From SessionState
+	To   SessionState
+	// 変化のきっかけになったイベント、コマンドの失敗やモジュールのリセットの場合は nil
+	Event *EVENT
+}
+
+// PANA セッションの情報
+type Session struct {
+	State SessionState
+	// 接続先の IPv6 アドレス
+	Peer string
+	// 最後に認証が成功した時刻
+	AuthenticatedAt time.Time
+	// 接続時に読み出した PANA セッションライフタイム、読み出せなかった場合は 0
+	Lifetime time.Duration
+	// 接続時に読み出した自動再認証フラグ
+	AutoReAuthentication bool
+}
+
+// ライフタイムが切れる時刻を返します。ライフタイムが分からない場合はゼロ値を返します。
+func (s Session) ExpiresAt() time.Time {
+	if s.Lifetime == 0 || s.AuthenticatedAt.IsZero() {
+		return time.Time{}
+	}
+	return s.AuthenticatedAt.Add(s.Lifetime)
+}
+
+// 現在の PANA セッションの情報を返します。
+func (m *MB_RL7023_11) Session() Session {
+	m.sessionMu.Lock()
+	defer m.sessionMu.Unlock()
+
+	return m.session
+}
+
+// PANA セッションの状態の変化を購読します。
+func (m *MB_RL7023_11) SubscribeSession(size int) *Subscription[*SessionStateChange] {
+	return Subscribe[*SessionStateChange](m, size, nil)
+}
+
+// EVENT 24 から 29 で PANA セッションの状態を更新します。
+func (m *MB_RL7023_11) observeSession(e *EVENT) {
+	switch e.Num {
+	case EVENTNumPANAConnectionFailed:
+		m.transitSession(e, SessionStateFailed, SessionStateJoining, SessionStateReauthenticating, SessionStateConnected)
+	case EVENTNumPANAConnected:
+		// 自動再認証が成功した場合は Connected のまま届く
+		m.transitSession(e, SessionStateConnected, SessionStateJoining, SessionStateReauthenticating, SessionStateConnected)
+	case EVENTNumSessionCloseRequestReceived, EVENTNumPANASessionClosed, EVENTNumPANASessionCloseResponseTimeout:
+		m.transitSession(e, SessionStateClosed, SessionStateJoining, SessionStateReauthenticating, SessionStateConnected)
+	case EVENTNumPANASessionTimeout:
+		m.transitSession(e, SessionStateFailed, SessionStateJoining, SessionStateReauthenticating, SessionStateConnected)
+	}
+}
+
+// 現在の状態が from のいずれかの場合に to へ変更し、変化を購読者に配信します。
+func (m *MB_RL7023_11) transitSession(e *EVENT, to SessionState, from ...SessionState) bool {
+	m.sessionMu.Lock()
+	prev := m.session.State
+	if len(from) > 0 && !slices.Contains(from, prev) {
+		m.sessionMu.Unlock()
+		return false
+	}
+	m.session.State = to
+	if to == SessionStateConnected {
+		m.session.AuthenticatedAt = time.Now()
+		if e != nil {
+			m.session.Peer = e.Sender
+		}
+	}
+	m.sessionMu.Unlock()
+
+	if prev == to {
+		return true
+	}
+
+	m.subsMu.RLock()
+	defer m.subsMu.RUnlock()
+
+	c := &SessionStateChange{From: prev, To: to, Event: e}
+	for _, s := range m.subs {
+		s.deliver(c)
+	}
+	return true
+}
+
+// SKJOIN、SKREJOIN を始める前に呼び出します。
+func (m *MB_RL7023_11) beginSession(state SessionState, peer string) {
+	if peer != "" {
+		m.sessionMu.Lock()
+		m.session.Peer = peer
+		m.session.Lifetime = 0
+		m.session.AutoReAuthentication = false
+		m.sessionMu.Unlock()
+	}
+	m.transitSession(nil, state)
+}
+
+// SKJOIN、SKREJOIN が終わった後に呼び出します。
+// イベントを受け取れずに失敗した場合は Failed にし、成功した場合はライフタイムと自動再認証フラグを読み出します。
+func (m *MB_RL7023_11) endSession(ctx context.Context, err error) {
+	if err != nil {
+		m.transitSession(nil, SessionStateFailed, SessionStateJoining, SessionStateReauthenticating)
+		return
+	}
+
+	lifetime, err := m.PANASessionLifetime(ctx)
+	if err != nil {
+		m.logger.Warn("Failed to read PANA session lifetime", "error", err)
+		lifetime = 0
+	}
+	auto, err := m.AutoReAuthentication(ctx)
+	if err != nil {
+		m.logger.Warn("Failed to read auto re-authentication flag", "error", err)
+	}
+
+	m.sessionMu.Lock()
+	m.session.Lifetime = lifetime
+	m.session.AutoReAuthentication = auto
+	m.sessionMu.Unlock()
+}
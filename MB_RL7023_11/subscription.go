@@ -0,0 +1,157 @@
+package MB_RL7023_11
+
+import (
+	"slices"
+	"sync/atomic"
+)
+
+// イベントの購読、受信したイベントは C に送られます。
+// C のバッファーが一杯の場合、シリアルの読み込みを止めないようにイベントは捨てられ、Dropped で数えられます。
+type Subscription[T any] struct {
+	C <-chan T
+
+	ch      chan T
+	dropped atomic.Uint64
+	filter  func(T) bool
+	m       *MB_RL7023_11
+}
+
+type subscriber interface {
+	deliver(event any)
+	close()
+}
+
+func (s *Subscription[T]) deliver(event any) {
+	e, ok := event.(T)
+	if !ok {
+		return
+	}
+	if s.filter != nil && !s.filter(e) {
+		return
+	}
+
+	select {
+	case s.ch <- e:
+	default:
+		s.dropped.Add(1)
+		s.m.dropped.Add(1)
+	}
+}
+
+func (s *Subscription[T]) close() {
+	close(s.ch)
+}
+
+// バッファーが一杯で捨てたイベントの数を返します。
+func (s *Subscription[T]) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// 購読をやめて C を閉じます。
+func (s *Subscription[T]) Close() {
+	s.m.unsubscribe(s)
+}
+
+// T 型のイベントを購読します。size は C のバッファーの大きさで、filter が nil でなければ true を返したイベントのみを送ります。
+func Subscribe[T any](m *MB_RL7023_11, size int, filter func(T) bool) *Subscription[T] {
+	ch := make(chan T, size)
+	s := &Subscription[T]{
+		C:      ch,
+		ch:     ch,
+		filter: filter,
+		m:      m,
+	}
+
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+
+	m.subs = append(m.subs, s)
+
+	return s
+}
+
+// 自端末宛ての UDP を購読します。
+func (m *MB_RL7023_11) SubscribeERXUDP(size int) *Subscription[*ERXUDP] {
+	return Subscribe[*ERXUDP](m, size, nil)
+}
+
+// 汎用イベントを購読します。nums を指定した場合はそのイベント番号のみを送ります。
+func (m *MB_RL7023_11) SubscribeEVENT(size int, nums ...EVENTNum) *Subscription[*EVENT] {
+	var filter func(*EVENT) bool
+	if len(nums) > 0 {
+		filter = func(e *EVENT) bool {
+			return slices.Contains(nums, e.Num)
+		}
+	}
+	return Subscribe(m, size, filter)
+}
+
+// アクティブスキャンで発見した PAN を購読します。
+func (m *MB_RL7023_11) SubscribeEPANDESC(size int) *Subscription[*EPANDESC] {
+	return Subscribe[*EPANDESC](m, size, nil)
+}
+
+// すべての購読でバッファーが一杯で捨てたイベントの数を返します。
+func (m *MB_RL7023_11) Dropped() uint64 {
+	return m.dropped.Load()
+}
+
+func (m *MB_RL7023_11) unsubscribe(s subscriber) {
+	m.subsMu.Lock()
+	defer m.subsMu.Unlock()
+
+	i := slices.Index(m.subs, s)
+	if i == -1 {
+		return
+	}
+	m.subs = slices.Delete(m.subs, i, i+1)
+	s.close()
+}
+
+// 通信路が開き直され、モジュールの状態 (PANA セッションや設定) が失われた可能性があることを通知します。
+// Initialize からやり直す必要があります。
+type ModuleReset struct{}
+
+// モジュールの状態が失われたことを購読します。
+func (m *MB_RL7023_11) SubscribeModuleReset(size int) *Subscription[*ModuleReset] {
+	return Subscribe[*ModuleReset](m, size, nil)
+}
+
+func (m *MB_RL7023_11) reset() {
+	// モジュールの状態と一緒に PANA セッションも失われる
+	m.transitSession(nil, SessionStateIdle)
+
+	m.subsMu.RLock()
+	defer m.subsMu.RUnlock()
+
+	for _, s := range m.subs {
+		s.deliver(&ModuleReset{})
+	}
+}
+
+// 受信した行をイベントに変換して購読者に配信します。Transport の AddListner に登録します。
+func (m *MB_RL7023_11) listener(lines []string) error {
+	events := m.Dialect().ParseEvent(lines)
+	if len(events) == 0 {
+		return nil
+	}
+
+	// イベントを受け取った購読者が古い状態を見ないよう、配信より先に状態を更新する
+	for _, e := range events {
+		if e, ok := e.(*EVENT); ok {
+			m.observeTransmit(e)
+			m.observeSession(e)
+		}
+	}
+
+	m.subsMu.RLock()
+	defer m.subsMu.RUnlock()
+
+	for _, e := range events {
+		for _, s := range m.subs {
+			s.deliver(e)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,365 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTCPClosed   = errors.New("tcp connection closed by peer")
+	ErrTCPDataLost = errors.New("tcp data lost")
+)
+
+const (
+	// SKSEND で一度に送れるデータ長
+	maxTCPPayload = 1232
+	// 受信したデータを Read されるまで溜めておくイベントの数
+	tcpBufferSize = 64
+)
+
+var _ net.Conn = (*TCPConn)(nil)
+
+// モジュールの TCP コネクションを net.Conn として扱います。
+type TCPConn struct {
+	m      *MB_RL7023_11
+	handle uint8
+	local  *net.TCPAddr
+	remote *net.TCPAddr
+
+	rx     *Subscription[*ERXTCP]
+	events *Subscription[*ETCP]
+
+	readMu sync.Mutex
+	buff   []uint8
+	// このコネクションの接続確立の ETCP を受信した
+	established bool
+	eof         bool
+
+	writeMu sync.Mutex
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// ipaddr の rport に lport から TCP で接続します。
+// lport が他のハンドルで使われたままの場合は、そのハンドルを閉じてからやり直します。
+func (m *MB_RL7023_11) DialTCP(ctx context.Context, ipaddr string, rport uint16, lport uint16) (*TCPConn, error) {
+	// 接続直後に届くデータや切断を取りこぼさないよう先に購読する
+	rx := Subscribe(m, tcpBufferSize, func(e *ERXTCP) bool {
+		return e.Sender == ipaddr && e.Rport == rport && e.Lport == lport
+	})
+	// ハンドル番号は SKCONNECT の結果で分かるので、それまではこの接続の確立と切断のみを受け取る
+	var handle atomic.Uint32
+	events := Subscribe(m, tcpBufferSize, func(e *ETCP) bool {
+		h := handle.Load()
+		switch e.Status {
+		case ETCPStatusConnected:
+			return (h == 0 || uint32(e.Handle) == h) && e.IPAddr == ipaddr && e.Rport == rport && e.Lport == lport
+		case ETCPStatusClosed:
+			return h == 0 || uint32(e.Handle) == h
+		default:
+			return false
+		}
+	})
+
+	e, err := m.SKCONNECT(ctx, ipaddr, rport, lport)
+	if errors.Is(err, ErrFailedToConnect) && e.Status == ETCPStatusSourcePortAlreadyUsed {
+		m.logger.Warn("Local port already used, closing stale TCP handle", "lport", lport)
+		err = m.closeStaleTCPHandle(ctx, lport)
+		if err == nil {
+			e, err = m.SKCONNECT(ctx, ipaddr, rport, lport)
+		}
+	}
+	if err != nil {
+		rx.Close()
+		events.Close()
+		return nil, err
+	}
+	handle.Store(uint32(e.Handle))
+
+	c := &TCPConn{
+		m:             m,
+		handle:        e.Handle,
+		remote:        &net.TCPAddr{IP: net.ParseIP(ipaddr), Port: int(rport)},
+		local:         &net.TCPAddr{Port: int(lport)},
+		rx:            rx,
+		events:        events,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		done:          make(chan struct{}),
+	}
+	if addrs := m.localAddrs(); len(addrs) > 0 {
+		c.local.IP = net.ParseIP(addrs[0])
+	}
+
+	return c, nil
+}
+
+// lport を使っているハンドルを SKTABLE で探して閉じます。
+func (m *MB_RL7023_11) closeStaleTCPHandle(ctx context.Context, lport uint16) error {
+	res, err := m.SKTABLE(ctx, SKTABLEModeTCPHandle)
+	if err != nil {
+		return err
+	}
+	ehandle, ok := res.(*EHANDLE)
+	if !ok {
+		return ErrUnexpectedOutput
+	}
+
+	for _, h := range ehandle.Handle {
+		if h.Lport == lport {
+			return m.SKCLOSE(ctx, h.Handle)
+		}
+	}
+
+	return ErrPortUnavaiable
+}
+
+// モジュールの TCP ハンドル番号を返します。
+func (c *TCPConn) Handle() uint8 {
+	return c.handle
+}
+
+func (c *TCPConn) Read(b []uint8) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for len(c.buff) == 0 {
+		if c.rx.Dropped() > 0 {
+			return 0, ErrTCPDataLost
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+
+		select {
+		case <-c.done:
+			return 0, net.ErrClosed
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case e, ok := <-c.rx.C:
+			if !ok {
+				return 0, net.ErrClosed
+			}
+			c.buff = append(c.buff, e.Data...)
+		case e, ok := <-c.events.C:
+			if !ok {
+				return 0, net.ErrClosed
+			}
+			c.event(e)
+		}
+	}
+
+	n := copy(b, c.buff)
+	c.buff = c.buff[n:]
+	return n, nil
+}
+
+// 同じハンドル番号は以前のコネクションでも使われているので、接続確立より後の切断だけを扱います。
+// c.readMu を保持して呼び出します。
+func (c *TCPConn) event(e *ETCP) {
+	if e.Handle != c.handle {
+		return
+	}
+
+	switch e.Status {
+	case ETCPStatusConnected:
+		c.established = true
+	case ETCPStatusClosed:
+		if c.established {
+			// 切断より前に受信したデータは配信済みなので、読み残しを取り出してから EOF にする
+			c.drain()
+			c.eof = true
+		}
+	}
+}
+
+func (c *TCPConn) drain() {
+	for {
+		select {
+		case e, ok := <-c.rx.C:
+			if !ok {
+				return
+			}
+			c.buff = append(c.buff, e.Data...)
+		default:
+			return
+		}
+	}
+}
+
+func (c *TCPConn) Write(b []uint8) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	ctx, cancel := c.writeDeadline.context(c.done)
+	defer cancel()
+
+	n := 0
+	for len(b) > 0 {
+		chunk := b[:min(len(b), maxTCPPayload)]
+
+		e, err := c.m.SKSEND(ctx, c.handle, chunk)
+		if err != nil {
+			return n, c.writeDeadline.err(c.done, err)
+		}
+		if e.Status == ETCPStatusClosed {
+			return n, ErrTCPClosed
+		}
+
+		n += len(chunk)
+		b = b[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// SKCLOSE で切断します。相手から切断されていた場合はモジュールへの要求を省きます。
+func (c *TCPConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+
+		c.readMu.Lock()
+		for !c.eof && len(c.events.C) > 0 {
+			c.event(<-c.events.C)
+		}
+		eof := c.eof
+		c.readMu.Unlock()
+
+		err = nil
+		if !eof {
+			ctx, cancel := context.WithTimeout(context.Background(), execTimeout*2*time.Second)
+			defer cancel()
+			err = c.m.SKCLOSE(ctx, c.handle)
+		}
+
+		c.rx.Close()
+		c.events.Close()
+	})
+	return err
+}
+
+func (c *TCPConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *TCPConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *TCPConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *TCPConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *TCPConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// 期限を過ぎると閉じるチャンネル、待っている途中で期限を変更しても反映されます。
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// 期限切れを通知している途中
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.cancel
+}
+
+// 期限を過ぎるか done が閉じられると取り消される context を返します。
+func (d *deadline) context(done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-done:
+		case <-d.wait():
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+	return ctx, cancel
+}
+
+// context で取り消されたコマンドのエラーを net.Conn のエラーに置き換えます。
+func (d *deadline) err(done <-chan struct{}, err error) error {
+	select {
+	case <-done:
+		return net.ErrClosed
+	case <-d.wait():
+		if errors.Is(err, ErrTransmitLimited) {
+			return fmt.Errorf("%w: %w", os.ErrDeadlineExceeded, err)
+		}
+		return os.ErrDeadlineExceeded
+	default:
+		return err
+	}
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+const tcpPeer = "FE80:0000:0000:0000:021D:1290:1234:5678"
+
+// SKCONNECT にハンドル 2 での接続確立を返す Transport
+type connectTransport struct {
+	tableTransport
+}
+
+func (t *connectTransport) Exec(ctx context.Context, command []uint8, stopper func(l []string) bool) ([]string, error) {
+	echo := strings.TrimSuffix(string(command), "\r\n")
+	if strings.HasPrefix(echo, "SKCONNECT ") {
+		return []string{echo, "OK", "ETCP 1 2 " + tcpPeer + " 0E1A 0E1A"}, nil
+	}
+	return t.tableTransport.Exec(ctx, command, stopper)
+}
+
+// 他のハンドルの ETCP はコネクションの購読に入らない
+func TestDialTCPSubscribesOwnHandle(t *testing.T) {
+	ctx := context.Background()
+	m := New(Config{Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), Transport: &connectTransport{}})
+	err := m.Initialize(ctx)
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	c, err := m.DialTCP(ctx, tcpPeer, 0x0E1A, 0x0E1A)
+	if err != nil {
+		t.Fatalf("DialTCP: %v", err)
+	}
+	if c.Handle() != 2 {
+		t.Fatalf("Handle() = %d, want 2", c.Handle())
+	}
+
+	err = m.listener([]string{
+		"ETCP 1 1 FE80:0000:0000:0000:021D:1290:AAAA:BBBB 0050 0E1A",
+		"ETCP 3 1",
+		"ETCP 5 2",
+		"ETCP 3 2",
+	})
+	if err != nil {
+		t.Fatalf("listener: %v", err)
+	}
+
+	if n := len(c.events.C); n != 1 {
+		t.Fatalf("received %d ETCP events, want 1", n)
+	}
+	if e := <-c.events.C; e.Handle != 2 || e.Status != ETCPStatusClosed {
+		t.Errorf("ETCP = %+v, want closed on handle 2", e)
+	}
+}
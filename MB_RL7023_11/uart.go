@@ -0,0 +1,138 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ホスト側のボーレートを変更できない Transport で返します。ser2net などの TCP 接続では探索もできません。
+	ErrBaudRateUnsupported = errors.New("baud rate change unsupported")
+	ErrModuleNotResponding = errors.New("module not responding")
+	ErrUARTNotApplied      = errors.New("uart mode not applied")
+)
+
+const probeTimeout = 1 * time.Second
+
+// ホスト側のボーレートを変更できる Transport が実装します。
+// 変更できない接続の場合、SetBaudRate は ErrBaudRateUnsupported を返します。
+type BaudRateSetter interface {
+	SetBaudRate(baudRate int) error
+}
+
+// モジュールが応答するか確かめます。
+func (m *MB_RL7023_11) ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	// ボーレートが合っていない間に受信した途中の行を区切る
+	_, err := m.transport.Write([]uint8("\r\n"))
+	if err != nil {
+		return err
+	}
+
+	_, err = m.SKVER(ctx)
+	return err
+}
+
+// UART 設定を変更し、ホスト側のボーレートも合わせます。
+// 新しいボーレートで応答がない場合はホスト側を元に戻し、ErrUARTNotApplied を返します。
+func (m *MB_RL7023_11) SetUART(ctx context.Context, mode UARTMode) error {
+	setter, ok := m.transport.(BaudRateSetter)
+	if !ok {
+		return ErrBaudRateUnsupported
+	}
+
+	current, err := m.RUART(ctx)
+	if err != nil {
+		return err
+	}
+	if current == mode {
+		return nil
+	}
+
+	err = m.WUART(ctx, mode)
+	if err != nil {
+		return err
+	}
+	if current.BaudRate() == mode.BaudRate() {
+		return nil
+	}
+
+	err = setter.SetBaudRate(mode.BaudRate())
+	if err != nil {
+		return err
+	}
+	err = m.ping(ctx)
+	if err == nil {
+		return nil
+	}
+	m.logger.Warn("Module not responding at new baud rate, reverting", "baud_rate", mode.BaudRate(), "err", err)
+
+	err = setter.SetBaudRate(current.BaudRate())
+	if err != nil {
+		return err
+	}
+	err = m.ping(ctx)
+	if err != nil {
+		return ErrModuleNotResponding
+	}
+
+	return ErrUARTNotApplied
+}
+
+// 現在のボーレート current で応答がなければ、よく使われるボーレートを順に試し、モジュールが応答したボーレートを返します。
+// ホスト側は見つかったボーレートのままになり、見つからなかった場合は current に戻します。
+// ホスト側のボーレートを変更できない Transport では、探索せずに ErrBaudRateUnsupported を返します。
+func (m *MB_RL7023_11) ProbeBaudRate(ctx context.Context, current int) (int, error) {
+	err := m.ping(ctx)
+	if err == nil {
+		return current, nil
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	setter, ok := m.transport.(BaudRateSetter)
+	if !ok {
+		return 0, ErrBaudRateUnsupported
+	}
+
+	// 見つからなかった場合は、変更したホスト側のボーレートを元に戻す
+	changed, found := false, false
+	defer func() {
+		if !changed || found {
+			return
+		}
+		err := setter.SetBaudRate(current)
+		if err != nil {
+			m.logger.Warn("Failed to restore baud rate", "baud_rate", current, "err", err)
+		}
+	}()
+
+	for _, r := range []int{115200, 57600, 38400, 19200, 9600, 4800, 2400} {
+		if r == current {
+			continue
+		}
+
+		err := setter.SetBaudRate(r)
+		if err != nil {
+			// ser2net などは ErrBaudRateUnsupported を返す
+			return 0, err
+		}
+		changed = true
+
+		m.logger.Debug("Probing baud rate", "baud_rate", r)
+		err = m.ping(ctx)
+		if err == nil {
+			found = true
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+
+	return 0, ErrModuleNotResponding
+}
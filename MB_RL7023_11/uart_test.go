@@ -0,0 +1,121 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+)
+
+// module のボーレートが一致している間だけ SKVER に応答する Transport
+type baudRateTransport struct {
+	host   int
+	module int
+	set    []int
+}
+
+func (t *baudRateTransport) Write(b []uint8) (int, error) {
+	return len(b), nil
+}
+
+func (t *baudRateTransport) Exec(ctx context.Context, command []uint8, stopper func(l []string) bool) ([]string, error) {
+	if t.host != t.module {
+		return nil, context.DeadlineExceeded
+	}
+	return []string{"SKVER", "EVER 1.2.10", "OK"}, nil
+}
+
+func (t *baudRateTransport) AddListner(l *func(lines []string) error) {}
+
+func (t *baudRateTransport) SetBaudRate(baudRate int) error {
+	t.host = baudRate
+	t.set = append(t.set, baudRate)
+	return nil
+}
+
+func TestProbeBaudRate(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tr := &baudRateTransport{host: 115200, module: 9600}
+	m := New(Config{Logger: logger, Transport: tr})
+	got, err := m.ProbeBaudRate(context.Background(), 115200)
+	if err != nil {
+		t.Fatalf("ProbeBaudRate: %v", err)
+	}
+	if got != 9600 || tr.host != 9600 {
+		t.Errorf("ProbeBaudRate = %d, host = %d, want 9600, 9600", got, tr.host)
+	}
+
+	// どのボーレートでも応答しなければ、ホストを元のボーレートに戻す
+	tr = &baudRateTransport{host: 115200, module: 0}
+	m = New(Config{Logger: logger, Transport: tr})
+	_, err = m.ProbeBaudRate(context.Background(), 115200)
+	if !errors.Is(err, ErrModuleNotResponding) {
+		t.Errorf("ProbeBaudRate error = %v, want %v", err, ErrModuleNotResponding)
+	}
+	if tr.host != 115200 {
+		t.Errorf("host baud rate = %d after failed probe, want 115200 (set %v)", tr.host, tr.set)
+	}
+	if !slices.Contains(tr.set, 2400) {
+		t.Errorf("probed %v, want all baud rates", tr.set)
+	}
+}
+
+// ホスト側のボーレートを変更できない Transport、ser2net の TCP 接続にあたる
+type fixedBaudRateTransport struct {
+	baudRateTransport
+}
+
+func (t *fixedBaudRateTransport) SetBaudRate(baudRate int) error {
+	return ErrBaudRateUnsupported
+}
+
+func TestProbeBaudRateUnsupported(t *testing.T) {
+	tr := &fixedBaudRateTransport{baudRateTransport{host: 115200, module: 9600}}
+	m := New(Config{Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), Transport: tr})
+	_, err := m.ProbeBaudRate(context.Background(), 115200)
+	if !errors.Is(err, ErrBaudRateUnsupported) {
+		t.Errorf("ProbeBaudRate error = %v, want %v", err, ErrBaudRateUnsupported)
+	}
+}
+
+// WUART の MODE は bit 7 がフロー制御、bit 6-4 がキャラクター間インターバル、bit 3 が予約、bit 2-0 がボーレート
+func TestUARTModeEncoding(t *testing.T) {
+	tests := []struct {
+		mode        UARTMode
+		baudRate    int
+		interval    UARTInterval
+		flowControl bool
+	}{
+		{0x00, 115200, UARTIntervalNone, false},
+		{0x03, 9600, UARTIntervalNone, false},
+		{0x06, 57600, UARTIntervalNone, false},
+		{0x10, 115200, UARTInterval100us, false},
+		{0x22, 4800, UARTInterval200us, false},
+		{0x35, 38400, UARTInterval300us, false},
+		{0x41, 2400, UARTInterval400us, false},
+		{0x54, 19200, UARTInterval50us, false},
+		{0x80, 115200, UARTIntervalNone, true},
+		{0xD3, 9600, UARTInterval50us, true},
+	}
+	for _, tt := range tests {
+		got, err := NewUARTMode(tt.baudRate, tt.interval, tt.flowControl)
+		if err != nil {
+			t.Errorf("NewUARTMode(%d, %d, %v): %v", tt.baudRate, tt.interval, tt.flowControl, err)
+			continue
+		}
+		if got != tt.mode {
+			t.Errorf("NewUARTMode(%d, %d, %v) = %s, want %s", tt.baudRate, tt.interval, tt.flowControl, got, tt.mode)
+		}
+		if tt.mode.BaudRate() != tt.baudRate || tt.mode.Interval() != tt.interval || tt.mode.FlowControl() != tt.flowControl {
+			t.Errorf("UARTMode(%s) = %d, %d, %v, want %d, %d, %v", tt.mode, tt.mode.BaudRate(), tt.mode.Interval(), tt.mode.FlowControl(), tt.baudRate, tt.interval, tt.flowControl)
+		}
+	}
+
+	// 予約の bit 3 はインターバルに含めない
+	if got := UARTMode(0x08).Interval(); got != UARTIntervalNone {
+		t.Errorf("UARTMode(08).Interval() = %d, want %d", got, UARTIntervalNone)
+	}
+}
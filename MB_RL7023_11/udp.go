@@ -0,0 +1,194 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidAddr     = errors.New("invalid address")
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrPortInUse       = errors.New("port in use")
+)
+
+const (
+	// SKSENDTO で一度に送れるデータ長
+	maxUDPPayload = 1232
+	// 受信したデータグラムを ReadFrom されるまで溜めておく数、溢れた分は捨てる
+	udpBufferSize = 16
+)
+
+var _ net.PacketConn = (*UDPConn)(nil)
+
+// モジュールの UDP ハンドルを net.PacketConn として扱います。
+type UDPConn struct {
+	m      *MB_RL7023_11
+	handle uint8
+	local  *net.UDPAddr
+	sec    SKSENDTOSec
+
+	rx *Subscription[*ERXUDP]
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// UDP ハンドル handle の待ち受けポートを port に設定し、そのポートで送受信します。
+// ECHONET Lite や PANA が使っているハンドルを奪わないよう、handle は未使用か既に port で待ち受けている必要があります。
+// sec は送信時の暗号化の設定です。
+func (m *MB_RL7023_11) ListenUDP(ctx context.Context, handle uint8, port uint16, sec SKSENDTOSec) (*UDPConn, error) {
+	current, ok := m.port(handle)
+	if !ok {
+		return nil, ErrPortUnavaiable
+	}
+	if current != 0 && current != port {
+		return nil, fmt.Errorf("%w: handle %d is listening on %d", ErrPortInUse, handle, current)
+	}
+
+	err := m.SKUDPPORT(ctx, handle, port)
+	if err != nil {
+		return nil, err
+	}
+	m.tableMu.Lock()
+	if int(handle) <= len(m.ports) {
+		m.ports[handle-1] = port
+	}
+	m.tableMu.Unlock()
+
+	c := &UDPConn{
+		m:      m,
+		handle: handle,
+		local:  &net.UDPAddr{Port: int(port)},
+		sec:    sec,
+		rx: Subscribe(m, udpBufferSize, func(e *ERXUDP) bool {
+			return e.Lport == port
+		}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		done:          make(chan struct{}),
+	}
+	if addrs := m.localAddrs(); len(addrs) > 0 {
+		c.local.IP = net.ParseIP(addrs[0])
+	}
+
+	return c, nil
+}
+
+// UDP ハンドル handle の待ち受けポートを返します。未使用のハンドルは 0、ハンドルが無い場合は false を返します。
+func (m *MB_RL7023_11) port(handle uint8) (uint16, bool) {
+	m.tableMu.RLock()
+	defer m.tableMu.RUnlock()
+
+	if handle == 0 || int(handle) > len(m.ports) {
+		return 0, false
+	}
+	return m.ports[handle-1], true
+}
+
+// Initialize で読み出した自端末の IPv6 アドレスを返します。
+func (m *MB_RL7023_11) localAddrs() []string {
+	m.tableMu.RLock()
+	defer m.tableMu.RUnlock()
+
+	return m.addrs
+}
+
+// 受信したデータグラムを p に読み込みます。p に収まらない部分は捨てられます。
+func (c *UDPConn) ReadFrom(p []uint8) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	select {
+	case <-c.done:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	case e, ok := <-c.rx.C:
+		if !ok {
+			return 0, nil, net.ErrClosed
+		}
+		addr := &net.UDPAddr{IP: net.ParseIP(e.Sender), Port: int(e.Rport)}
+		return copy(p, e.Data), addr, nil
+	}
+}
+
+// SKSENDTO で addr に送信し、送信完了を待ちます。
+func (c *UDPConn) WriteTo(p []uint8, addr net.Addr) (int, error) {
+	a, ok := addr.(*net.UDPAddr)
+	if !ok || a.IP.To16() == nil || a.Port <= 0 || a.Port > 0xFFFF {
+		return 0, ErrInvalidAddr
+	}
+	if len(p) > maxUDPPayload {
+		return 0, ErrPayloadTooLarge
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	ctx, cancel := c.writeDeadline.context(c.done)
+	defer cancel()
+
+	err := c.m.SKSENDTONoReply(ctx, c.handle, formatIPv6(a.IP), uint16(a.Port), c.sec, SKSENDTOReservedValue, p)
+	if err != nil {
+		return 0, c.writeDeadline.err(c.done, err)
+	}
+
+	return len(p), nil
+}
+
+// 購読をやめます。待ち受けポートの設定はそのまま残ります。
+func (c *UDPConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.rx.Close()
+		err = nil
+	})
+	return err
+}
+
+// 受信バッファーが一杯で捨てたデータグラムの数を返します。
+func (c *UDPConn) Dropped() uint64 {
+	return c.rx.Dropped()
+}
+
+func (c *UDPConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *UDPConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *UDPConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *UDPConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// モジュールが受け付ける、省略のない形式で IPv6 アドレスを表します。
+func formatIPv6(ip net.IP) string {
+	ip = ip.To16()
+	return fmt.Sprintf(
+		"%02X%02X:%02X%02X:%02X%02X:%02X%02X:%02X%02X:%02X%02X:%02X%02X:%02X%02X",
+		ip[0], ip[1], ip[2], ip[3], ip[4], ip[5], ip[6], ip[7],
+		ip[8], ip[9], ip[10], ip[11], ip[12], ip[13], ip[14], ip[15],
+	)
+}
@@ -0,0 +1,99 @@
+package MB_RL7023_11
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Initialize に必要な応答と OK だけを返し、コマンドを記録する Transport
+type tableTransport struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (t *tableTransport) Write(b []uint8) (int, error) {
+	return len(b), nil
+}
+
+func (t *tableTransport) AddListner(l *func(lines []string) error) {}
+
+func (t *tableTransport) Exec(ctx context.Context, command []uint8, stopper func(l []string) bool) ([]string, error) {
+	echo := strings.TrimSuffix(string(command), "\r\n")
+	t.mu.Lock()
+	t.commands = append(t.commands, echo)
+	t.mu.Unlock()
+
+	switch echo {
+	case "ROPT":
+		return []string{echo, "FAIL ER04"}, nil
+	case "SKTABLE 1":
+		return []string{echo, "EADDR", "FE80:0000:0000:0000:021D:1290:0000:0001", "OK"}, nil
+	case "SKTABLE E":
+		// ECHONET Lite と PANA だけが待ち受けている
+		return []string{echo, "EPORT", "3610", "716", "0", "0", "0", "0", "", "0", "0", "0", "0", "OK"}, nil
+	default:
+		return []string{echo, "OK"}, nil
+	}
+}
+
+func (t *tableTransport) sent(command string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return slices.Contains(t.commands, command)
+}
+
+func TestListenUDPFreeHandle(t *testing.T) {
+	ctx := context.Background()
+	tr := &tableTransport{}
+	m := New(Config{Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), Transport: tr})
+	err := m.Initialize(ctx)
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	if got, _ := m.port(3); got != 0 {
+		t.Fatalf("port(3) = %d, want 0 for a free handle", got)
+	}
+
+	c, err := m.ListenUDP(ctx, 3, 0x1234, SKSENDTOSecModerate)
+	if err != nil {
+		t.Fatalf("ListenUDP on free handle: %v", err)
+	}
+	defer c.Close()
+	if !tr.sent("SKUDPPORT 3 1234") {
+		t.Error("SKUDPPORT 3 1234 not sent")
+	}
+	if got, _ := m.port(3); got != 0x1234 {
+		t.Errorf("port(3) = %d, want %d", got, 0x1234)
+	}
+	if ip := c.LocalAddr().String(); !strings.Contains(ip, "fe80::21d:1290:0:1") {
+		t.Errorf("LocalAddr = %s, want the module address", ip)
+	}
+
+	// ECHONET Lite のハンドルは奪わない
+	_, err = m.ListenUDP(ctx, 1, 0x1234, SKSENDTOSecModerate)
+	if !errors.Is(err, ErrPortInUse) {
+		t.Errorf("ListenUDP on handle 1 = %v, want %v", err, ErrPortInUse)
+	}
+	if got, _ := m.port(1); got != 3610 {
+		t.Errorf("port(1) = %d, want 3610", got)
+	}
+
+	_, err = m.ListenUDP(ctx, 7, 0x1234, SKSENDTOSecModerate)
+	if !errors.Is(err, ErrPortUnavaiable) {
+		t.Errorf("ListenUDP on handle 7 = %v, want %v", err, ErrPortUnavaiable)
+	}
+
+	// 未使用のハンドルからは送れない
+	_, err = m.SKSENDTO(ctx, 4, "FE80:0000:0000:0000:021D:1290:1234:5678", 0x0E1A, SKSENDTOSecModerate, SKSENDTOReservedValue, []uint8("x"))
+	if !errors.Is(err, ErrPortUnavaiable) {
+		t.Errorf("SKSENDTO on free handle = %v, want %v", err, ErrPortUnavaiable)
+	}
+}
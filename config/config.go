@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property/smartmeter"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+type Config struct {
+	Serial  Serial  `yaml:"serial"`
+	RouteB  RouteB  `yaml:"route_b"`
+	PAN     PAN     `yaml:"pan"`
+	Poll    Poll    `yaml:"poll"`
+	Metrics Metrics `yaml:"metrics"`
+	Log     Log     `yaml:"log"`
+}
+
+// Wi-SUN モジュールとの接続設定
+type Serial struct {
+	Port     string `yaml:"port"`
+	BaudRate int    `yaml:"baud_rate"`
+	// 実機の代わりに内蔵のシミュレーターを使う
+	Simulate bool `yaml:"simulate"`
+	// 指定した場合、読み書きしたデータをこのファイルに記録する
+	// 認証情報は伏せるが、メーターの識別番号や計測値などはそのまま残るので、所有者だけが読める権限で作る
+	Capture string `yaml:"capture"`
+	// モジュールの種類、auto の場合は SKVER から推測し、判別できなければエラーにする
+	Module string `yaml:"module"`
+}
+
+// B ルートの認証情報
+type RouteB struct {
+	ID       string `yaml:"id"`
+	Password string `yaml:"password"`
+}
+
+// 接続先の PAN、未指定の場合はスキャンして探す
+type PAN struct {
+	Channel   *uint8  `yaml:"channel"`
+	PanID     *uint16 `yaml:"pan_id"`
+	Addr      string  `yaml:"addr"`
+	PairID    string  `yaml:"pair_id"`
+	StateFile string  `yaml:"state_file"`
+}
+
+// 3 つすべてが指定されている場合のみ接続先として扱う
+func (p PAN) IsFixed() bool {
+	return p.Channel != nil && p.PanID != nil && p.Addr != ""
+}
+
+type Poll struct {
+	Interval time.Duration `yaml:"interval"`
+	EPCs     []string      `yaml:"epcs"`
+}
+
+func (p Poll) ParseEPCs() ([]property.EPC, error) {
+	epcs := make([]property.EPC, len(p.EPCs))
+	for i, v := range p.EPCs {
+		epc, err := parseHex(v, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EPC %q: %w", v, err)
+		}
+		epcs[i] = property.EPC(epc)
+	}
+	return epcs, nil
+}
+
+type Metrics struct {
+	Enabled       bool   `yaml:"enabled"`
+	ListenAddress string `yaml:"listen_address"`
+}
+
+type Log struct {
+	Verbose bool `yaml:"verbose"`
+}
+
+func Default() *Config {
+	return &Config{
+		Serial: Serial{
+			BaudRate: 115200,
+			Module:   "mb-rl7023-11",
+		},
+		Poll: Poll{
+			Interval: 60 * time.Second,
+			EPCs: []string{
+				smartmeter.EPCMeasuredInstantaneousElectricPower.String(),
+				smartmeter.EPCMeasuredInstantaneousCurrents.String(),
+				smartmeter.EPCMeasuredCumulativeAmountOfElectricEnergyNormalDirection.String(),
+				smartmeter.EPCMeasuredCumulativeAmountOfElectricEnergyReverseDirection.String(),
+			},
+		},
+		Metrics: Metrics{
+			Enabled:       true,
+			ListenAddress: ":9888",
+		},
+	}
+}
+
+// 設定ファイルを読み込み、c に上書きします。未知のキーはエラーになります。
+func (c *Config) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	d := yaml.NewDecoder(bytes.NewReader(b))
+	d.KnownFields(true)
+	err = d.Decode(c)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// ROUTE_B_* 環境変数で c を上書きします。
+func (c *Config) LoadEnv(getenv func(string) string) error {
+	if v := getenv("ROUTE_B_ID"); v != "" {
+		c.RouteB.ID = v
+	}
+	if v := getenv("ROUTE_B_PASSWORD"); v != "" {
+		c.RouteB.Password = v
+	}
+	if v := getenv("ROUTE_B_CHANNEL"); v != "" {
+		channel, err := parseHex(v, 8)
+		if err != nil {
+			return fmt.Errorf("ROUTE_B_CHANNEL: %w", err)
+		}
+		c.PAN.Channel = ptr(uint8(channel))
+	}
+	if v := getenv("ROUTE_B_PANID"); v != "" {
+		panid, err := parseHex(v, 16)
+		if err != nil {
+			return fmt.Errorf("ROUTE_B_PANID: %w", err)
+		}
+		c.PAN.PanID = ptr(uint16(panid))
+	}
+	if v := getenv("ROUTE_B_ADDR"); v != "" {
+		c.PAN.Addr = v
+	}
+	if v := getenv("ROUTE_B_PAIRID"); v != "" {
+		c.PAN.PairID = v
+	}
+
+	return nil
+}
+
+// 設定値を検証し、問題をすべてまとめて返します。
+func (c *Config) Validate() error {
+	return c.validate(false)
+}
+
+// サーベイモードの設定値を検証します。
+// サーベイモードでは PAN に接続しないので、ルート B の認証情報と PAN、ポーリングの設定は検証しません。
+func (c *Config) ValidateSurvey() error {
+	return c.validate(true)
+}
+
+func (c *Config) validate(survey bool) error {
+	var errs []error
+	invalid := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s: %s", ErrInvalidConfig, field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Serial.Port == "" && !c.Serial.Simulate {
+		invalid("serial.port", "serial port is required")
+	}
+	if !slices.Contains(MB_RL7023_11.UARTBaudRates, c.Serial.BaudRate) {
+		invalid("serial.baud_rate", "must be one of %v, got %d", MB_RL7023_11.UARTBaudRates, c.Serial.BaudRate)
+	}
+	if _, err := module.Lookup(c.Serial.Module); err != nil && c.Serial.Module != "auto" {
+		names := []string{"auto"}
+		for _, v := range module.Variants {
+			names = append(names, v.Name)
+		}
+		invalid("serial.module", "must be one of %v, got %q", names, c.Serial.Module)
+	}
+
+	if !survey {
+		if len(c.RouteB.ID) != 32 {
+			invalid("route_b.id", "must be 32 characters, got %d", len(c.RouteB.ID))
+		}
+		if len(c.RouteB.Password) == 0 || len(c.RouteB.Password) > 32 {
+			invalid("route_b.password", "must be 1 to 32 characters, got %d", len(c.RouteB.Password))
+		}
+
+		if c.PAN.Channel != nil && (*c.PAN.Channel < 0x21 || *c.PAN.Channel > 0x3C) {
+			invalid("pan.channel", "must be between 0x21 and 0x3C, got 0x%02X", *c.PAN.Channel)
+		}
+		if !c.PAN.IsFixed() && (c.PAN.Channel != nil || c.PAN.PanID != nil || c.PAN.Addr != "") {
+			invalid("pan", "channel, pan_id and addr must be set together")
+		}
+		if c.PAN.Addr != "" && net.ParseIP(c.PAN.Addr) == nil {
+			invalid("pan.addr", "must be an IPv6 address, got %q", c.PAN.Addr)
+		}
+
+		if c.Poll.Interval <= 0 {
+			invalid("poll.interval", "must be positive, got %s", c.Poll.Interval)
+		}
+		if len(c.Poll.EPCs) == 0 {
+			invalid("poll.epcs", "at least one EPC is required")
+		}
+		if _, err := c.Poll.ParseEPCs(); err != nil {
+			invalid("poll.epcs", "%s", err)
+		}
+	}
+
+	if c.Metrics.Enabled {
+		if _, _, err := net.SplitHostPort(c.Metrics.ListenAddress); err != nil {
+			invalid("metrics.listen_address", "%s", err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func parseHex(s string, bitSize int) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "0X"), 16, bitSize)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -100,6 +100,10 @@ type Frame struct {
 }
 
 func NewFrame(bytes []uint8) (*Frame, error) {
+	if len(bytes) < 12 {
+		return nil, ErrInvalidPacket
+	}
+
 	if bytes[0] != uint8(EHD1ECHONETLite) || bytes[1] != uint8(EHD2SpecifiedMessageFormat) {
 		return nil, ErrInvalidPacket
 	}
@@ -115,22 +119,34 @@ func NewFrame(bytes []uint8) (*Frame, error) {
 		},
 	}
 
-	opc := bytes[11]
+	opc := int(bytes[11])
 
 	props := make([]property.Property, opc)
-	for i := 12; i < len(bytes); i += 2 {
-		epc := bytes[i]
-		pdc := bytes[i+1]
-		edt := bytes[i+2 : i+2+int(pdc)]
-
-		parsed, err := parser.ParseProperty(e.EDATA.SEOJ, epc, edt)
-		if err != nil {
-			return nil, err
+	i := 12
+	for n := 0; n < opc; n++ {
+		if i+2 > len(bytes) {
+			return nil, ErrInvalidPacket
 		}
 
-		props[(i-12)/2] = parsed
+		epc := bytes[i]
+		pdc := int(bytes[i+1])
+		if i+2+pdc > len(bytes) {
+			return nil, ErrInvalidPacket
+		}
+		edt := bytes[i+2 : i+2+pdc]
+
+		// 不可応答や Get 要求では PDC が 0 になるため、値を解釈せずに保持する
+		if pdc == 0 {
+			props[n] = property.NewUnknownProperty(property.RawProperty{EPC: property.EPC(epc), EDT: edt})
+		} else {
+			parsed, err := parser.ParseProperty(e.EDATA.SEOJ, epc, edt)
+			if err != nil {
+				return nil, err
+			}
+			props[n] = parsed
+		}
 
-		i += int(pdc)
+		i += 2 + pdc
 	}
 
 	e.EDATA.Properties = props
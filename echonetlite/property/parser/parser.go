@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"errors"
+
 	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property"
 	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property/smartmeter"
 )
@@ -13,7 +15,11 @@ func ParseProperty(object [3]uint8, epc uint8, edt []uint8) (property.Property,
 
 	switch [2]uint8{object[0], object[1]} {
 	case [2]uint8{smartmeter.ClassGroupCode, smartmeter.ClassCode}:
-		return smartmeter.ParseProperty(r)
+		p, err := smartmeter.ParseProperty(r)
+		if errors.Is(err, property.ErrUnknownProperty) {
+			return property.NewUnknownProperty(r), nil
+		}
+		return p, err
 	}
 
 	return property.NewUnknownProperty(r), nil
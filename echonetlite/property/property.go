@@ -3,6 +3,7 @@ package property
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -15,6 +16,10 @@ var (
 // ECHONET Liteプロパティ
 type EPC uint8
 
+func (e EPC) String() string {
+	return fmt.Sprintf("%02X", uint8(e))
+}
+
 // ECHONET Liteプロパティ
 type RawProperty struct {
 	// ECHONET Liteプロパティ
@@ -0,0 +1,94 @@
+package smartmeter
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+var ErrInvalidScale = errors.New("invalid scale")
+
+// 積算電力量計測値を kWh に換算するための係数、単位、有効桁数
+type Scale struct {
+	Coefficient     uint32
+	Unit            float64
+	EffectiveDigits uint8
+}
+
+// 係数 (D3) は未実装のメーターがあるため nil の場合は 1 として扱います。
+func NewScale(c *Coefficient, d *NumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy, u *UnitForCumulativeAmountOfElectricEnergy) (*Scale, error) {
+	if d == nil || u == nil {
+		return nil, ErrInvalidScale
+	}
+
+	if d.Value < 1 || d.Value > 8 {
+		return nil, ErrInvalidScale
+	}
+
+	coefficient := uint32(1)
+	if c != nil && c.Value != 0 {
+		coefficient = c.Value
+	}
+
+	return &Scale{
+		Coefficient:     coefficient,
+		Unit:            u.Value,
+		EffectiveDigits: d.Value,
+	}, nil
+}
+
+// 積算電力量計測値が 0 に戻る値
+func (s *Scale) Modulus() uint64 {
+	return uint64(math.Pow10(int(s.EffectiveDigits)))
+}
+
+func (s *Scale) KWh(raw uint32) float64 {
+	return float64(raw) * float64(s.Coefficient) * s.Unit
+}
+
+// 有効桁数での桁あふれを補正し、単調増加する積算電力量を kWh で返します。
+type CumulativeEnergy struct {
+	mu    sync.Mutex
+	scale *Scale
+	last  uint32
+	valid bool
+	wraps uint64
+}
+
+func NewCumulativeEnergy(s *Scale) *CumulativeEnergy {
+	return &CumulativeEnergy{
+		scale: s,
+	}
+}
+
+// 計測値を記録して補正後の積算電力量を返します。
+// 有効桁数の半分を超えて値が減った場合は桁あふれ、それ以外の減少は計測のぶれとみなして直前の値を維持します。
+func (c *CumulativeEnergy) Add(raw uint32) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.valid && raw < c.last {
+		if uint64(c.last-raw) > c.scale.Modulus()/2 {
+			c.wraps++
+		} else {
+			raw = c.last
+		}
+	}
+
+	c.last = raw
+	c.valid = true
+
+	return c.value()
+}
+
+// 最後に記録した補正後の積算電力量を返します。
+func (c *CumulativeEnergy) Value() (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.value(), c.valid
+}
+
+func (c *CumulativeEnergy) value() float64 {
+	return c.scale.KWh(c.last) + float64(c.wraps)*float64(c.scale.Modulus())*float64(c.scale.Coefficient)*c.scale.Unit
+}
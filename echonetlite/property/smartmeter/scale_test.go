@@ -0,0 +1,124 @@
+package smartmeter
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
+
+// 積算電力量単位 (E1) の各コード
+func TestUnitForCumulativeAmountOfElectricEnergy(t *testing.T) {
+	tests := []struct {
+		code uint8
+		want float64
+		err  error
+	}{
+		{0x00, 1, nil},
+		{0x01, 0.1, nil},
+		{0x02, 0.01, nil},
+		{0x03, 0.001, nil},
+		{0x04, 0.0001, nil},
+		{0x0A, 10, nil},
+		{0x0B, 100, nil},
+		{0x0C, 1000, nil},
+		{0x0D, 10000, nil},
+		{0x05, 0, property.ErrInvalidPropertyData},
+		{0x09, 0, property.ErrInvalidPropertyData},
+		{0x0E, 0, property.ErrInvalidPropertyData},
+	}
+	for _, tt := range tests {
+		u, err := NewUnitForCumulativeAmountOfElectricEnergy(property.RawProperty{EPC: EPCUnitForCumulativeAmountOfElectricEnergy, EDT: []uint8{tt.code}})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("unit %02X error = %v, want %v", tt.code, err, tt.err)
+			continue
+		}
+		if err == nil && u.Value != tt.want {
+			t.Errorf("unit %02X = %v, want %v", tt.code, u.Value, tt.want)
+		}
+	}
+}
+
+func TestNewScale(t *testing.T) {
+	unit := &UnitForCumulativeAmountOfElectricEnergy{Value: 0.1}
+	digits := func(n uint8) *NumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy {
+		return &NumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy{Value: n}
+	}
+
+	tests := []struct {
+		name        string
+		coefficient *Coefficient
+		digits      *NumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy
+		unit        *UnitForCumulativeAmountOfElectricEnergy
+		want        Scale
+		modulus     uint64
+		err         error
+	}{
+		{"no coefficient", nil, digits(6), unit, Scale{Coefficient: 1, Unit: 0.1, EffectiveDigits: 6}, 1000000, nil},
+		{"zero coefficient", &Coefficient{Value: 0}, digits(6), unit, Scale{Coefficient: 1, Unit: 0.1, EffectiveDigits: 6}, 1000000, nil},
+		{"coefficient", &Coefficient{Value: 40}, digits(8), unit, Scale{Coefficient: 40, Unit: 0.1, EffectiveDigits: 8}, 100000000, nil},
+		{"one digit", nil, digits(1), unit, Scale{Coefficient: 1, Unit: 0.1, EffectiveDigits: 1}, 10, nil},
+		{"zero digits", nil, digits(0), unit, Scale{}, 0, ErrInvalidScale},
+		{"nine digits", nil, digits(9), unit, Scale{}, 0, ErrInvalidScale},
+		{"no digits", nil, nil, unit, Scale{}, 0, ErrInvalidScale},
+		{"no unit", nil, digits(6), nil, Scale{}, 0, ErrInvalidScale},
+	}
+	for _, tt := range tests {
+		s, err := NewScale(tt.coefficient, tt.digits, tt.unit)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if *s != tt.want {
+			t.Errorf("%s: NewScale = %+v, want %+v", tt.name, *s, tt.want)
+		}
+		if s.Modulus() != tt.modulus {
+			t.Errorf("%s: Modulus = %d, want %d", tt.name, s.Modulus(), tt.modulus)
+		}
+	}
+}
+
+func TestCumulativeEnergy(t *testing.T) {
+	// 有効桁数 6 桁、0.1kWh 単位なので 100000.0kWh で 0 に戻る
+	scale := &Scale{Coefficient: 1, Unit: 0.1, EffectiveDigits: 6}
+
+	tests := []struct {
+		name  string
+		scale *Scale
+		raws  []uint32
+		want  []float64
+	}{
+		{"increase", scale, []uint32{100, 150, 150}, []float64{10, 15, 15}},
+		// 有効桁数の半分以下の減少は計測のぶれとして直前の値を維持する
+		{"small decrease", scale, []uint32{500000, 499990, 500010}, []float64{50000, 50000, 50001}},
+		{"decrease of half the modulus", scale, []uint32{600000, 100000}, []float64{60000, 60000}},
+		{"decrease over half the modulus", scale, []uint32{600001, 100000}, []float64{60000.1, 110000}},
+		{"rollover at the modulus", scale, []uint32{999999, 0, 5}, []float64{99999.9, 100000, 100000.5}},
+		{"rollover twice", scale, []uint32{999990, 10, 999000, 20}, []float64{99999, 100001, 199900, 200002}},
+		{"coefficient", &Scale{Coefficient: 40, Unit: 0.01, EffectiveDigits: 4}, []uint32{9999, 1}, []float64{3999.6, 4000.4}},
+		{"unit of 10kWh", &Scale{Coefficient: 1, Unit: 10, EffectiveDigits: 2}, []uint32{90, 99, 3}, []float64{900, 990, 1030}},
+	}
+	for _, tt := range tests {
+		c := NewCumulativeEnergy(tt.scale)
+		if _, ok := c.Value(); ok {
+			t.Errorf("%s: Value before Add is valid", tt.name)
+		}
+		for i, raw := range tt.raws {
+			got := c.Add(raw)
+			if !almostEqual(got, tt.want[i]) {
+				t.Errorf("%s: Add(%d) = %v, want %v", tt.name, raw, got, tt.want[i])
+			}
+		}
+		if v, ok := c.Value(); !ok || !almostEqual(v, tt.want[len(tt.want)-1]) {
+			t.Errorf("%s: Value = %v, %v, want %v, true", tt.name, v, ok, tt.want[len(tt.want)-1])
+		}
+	}
+}
@@ -75,7 +75,7 @@ func NewRouteBIdentificationNumber(p property.RawProperty) (*RouteBIdentificatio
 	}
 
 	return &RouteBIdentificationNumber{
-		ManufacturerCode: string(p.EDT[1:3]),
+		ManufacturerCode: string(p.EDT[1:4]),
 		FreeArea:         string(p.EDT[4:]),
 	}, nil
 }
@@ -200,7 +200,7 @@ func NewMeasuredCumulativeAmountOfElectricEnergyNormalDirection(p property.RawPr
 const EPCUnitForCumulativeAmountOfElectricEnergy property.EPC = 0xE1
 
 type UnitForCumulativeAmountOfElectricEnergy struct {
-	Value float32
+	Value float64
 }
 
 func (u *UnitForCumulativeAmountOfElectricEnergy) ToSettable() property.RawProperty {
@@ -219,7 +219,7 @@ func NewUnitForCumulativeAmountOfElectricEnergy(p property.RawProperty) (*UnitFo
 		return nil, property.ErrInvalidPropertyData
 	}
 
-	var value float32
+	var value float64
 	switch p.EDT[0] {
 	case 0x00:
 		value = 1
@@ -239,6 +239,8 @@ func NewUnitForCumulativeAmountOfElectricEnergy(p property.RawProperty) (*UnitFo
 		value = 1000
 	case 0x0D:
 		value = 10000
+	default:
+		return nil, property.ErrInvalidPropertyData
 	}
 
 	return &UnitForCumulativeAmountOfElectricEnergy{
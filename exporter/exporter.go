@@ -0,0 +1,231 @@
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property/smartmeter"
+)
+
+const namespace = "smartmeter"
+
+var labels = []string{"manufacturer", "id", "addr"}
+
+var (
+	instantaneousElectricPowerDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "instantaneous_electric_power_watts"),
+		"Measured instantaneous electric power (E7).",
+		labels, nil,
+	)
+	instantaneousCurrentDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "instantaneous_current_amperes"),
+		"Measured instantaneous currents (E8).",
+		append(labels, "phase"), nil,
+	)
+	cumulativeElectricEnergyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "cumulative_electric_energy_kilowatt_hours_total"),
+		"Measured cumulative amount of electric energy (E0/E3) in kWh.",
+		append(labels, "direction"), nil,
+	)
+	fixedTimeCumulativeElectricEnergyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "fixed_time_cumulative_electric_energy_kilowatt_hours_total"),
+		"Cumulative amount of electric energy measured at fixed time (EA/EB) in kWh, timestamped by the meter.",
+		append(labels, "direction"), nil,
+	)
+)
+
+// スマートメーターの識別情報
+type Identity struct {
+	ManufacturerCode string
+	ID               string
+	Addr             string
+}
+
+func NewIdentity(r *smartmeter.RouteBIdentificationNumber, addr string) Identity {
+	if r == nil {
+		return Identity{Addr: addr}
+	}
+
+	return Identity{
+		ManufacturerCode: fmt.Sprintf("%X", r.ManufacturerCode),
+		ID:               fmt.Sprintf("%X", r.FreeArea),
+		Addr:             addr,
+	}
+}
+
+func (i Identity) values(extra ...string) []string {
+	return append([]string{i.ManufacturerCode, i.ID, i.Addr}, extra...)
+}
+
+type Exporter struct {
+	mu       sync.RWMutex
+	identity Identity
+	scale    *smartmeter.Scale
+
+	instantaneousElectricPower *smartmeter.MeasuredInstantaneousElectricPower
+	instantaneousCurrents      *smartmeter.MeasuredInstantaneousCurrents
+	cumulativeNormal           *smartmeter.CumulativeEnergy
+	cumulativeReverse          *smartmeter.CumulativeEnergy
+	fixedTimeNormal            *smartmeter.CumulativeEnergy
+	fixedTimeNormalAt          time.Time
+	fixedTimeReverse           *smartmeter.CumulativeEnergy
+	fixedTimeReverseAt         time.Time
+}
+
+func New() *Exporter {
+	return &Exporter{}
+}
+
+func (e *Exporter) SetIdentity(i Identity) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.identity = i
+}
+
+// 積算電力量の換算に使う値を設定します。値が変わった場合は桁あふれの補正状態を破棄します。
+func (e *Exporter) SetScale(s *smartmeter.Scale) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.scale != nil && *e.scale == *s {
+		return
+	}
+
+	e.scale = s
+	e.cumulativeNormal = smartmeter.NewCumulativeEnergy(s)
+	e.cumulativeReverse = smartmeter.NewCumulativeEnergy(s)
+	e.fixedTimeNormal = smartmeter.NewCumulativeEnergy(s)
+	e.fixedTimeNormalAt = time.Time{}
+	e.fixedTimeReverse = smartmeter.NewCumulativeEnergy(s)
+	e.fixedTimeReverseAt = time.Time{}
+}
+
+// 受信したプロパティを最新値として記録します。対象外のプロパティは無視して false を返します。
+func (e *Exporter) Update(p property.Property) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	switch v := p.(type) {
+	case *smartmeter.MeasuredInstantaneousElectricPower:
+		e.instantaneousElectricPower = v
+	case *smartmeter.MeasuredInstantaneousCurrents:
+		e.instantaneousCurrents = v
+	case *smartmeter.MeasuredCumulativeAmountOfElectricEnergyNormalDirection:
+		if e.scale == nil {
+			return false
+		}
+		e.cumulativeNormal.Add(v.Value)
+	case *smartmeter.MeasuredCumulativeAmountOfElectricEnergyReverseDirection:
+		if e.scale == nil {
+			return false
+		}
+		e.cumulativeReverse.Add(v.Value)
+	case *smartmeter.OneMinuteMeasuredCumulativeAmountsOfElectricEnergyMeasured:
+		if e.scale == nil {
+			return false
+		}
+		// 計測値が未取得の場合は 0xFFFFFFFE が返る
+		if v.Normal != 0xFFFFFFFE {
+			e.cumulativeNormal.Add(v.Normal)
+		}
+		if v.Reverse != 0xFFFFFFFE {
+			e.cumulativeReverse.Add(v.Reverse)
+		}
+	case *smartmeter.CumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection:
+		if e.scale == nil || v.Value == 0xFFFFFFFE || !v.MeasuredAt.After(e.fixedTimeNormalAt) {
+			return false
+		}
+		e.fixedTimeNormal.Add(v.Value)
+		e.fixedTimeNormalAt = v.MeasuredAt
+	case *smartmeter.CumulativeAmountOfElectricEnergyMeasuredAtFixedTimeReverseDirection:
+		if e.scale == nil || v.Value == 0xFFFFFFFE || !v.MeasuredAt.After(e.fixedTimeReverseAt) {
+			return false
+		}
+		e.fixedTimeReverse.Add(v.Value)
+		e.fixedTimeReverseAt = v.MeasuredAt
+	default:
+		return false
+	}
+
+	return true
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- instantaneousElectricPowerDesc
+	ch <- instantaneousCurrentDesc
+	ch <- cumulativeElectricEnergyDesc
+	ch <- fixedTimeCumulativeElectricEnergyDesc
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.instantaneousElectricPower != nil {
+		ch <- prometheus.MustNewConstMetric(
+			instantaneousElectricPowerDesc,
+			prometheus.GaugeValue,
+			float64(e.instantaneousElectricPower.Value),
+			e.identity.values()...,
+		)
+	}
+
+	if e.instantaneousCurrents != nil {
+		ch <- prometheus.MustNewConstMetric(
+			instantaneousCurrentDesc,
+			prometheus.GaugeValue,
+			float64(e.instantaneousCurrents.R),
+			e.identity.values("r")...,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			instantaneousCurrentDesc,
+			prometheus.GaugeValue,
+			float64(e.instantaneousCurrents.T),
+			e.identity.values("t")...,
+		)
+	}
+
+	if e.scale == nil {
+		return
+	}
+
+	if v, ok := e.cumulativeNormal.Value(); ok {
+		ch <- prometheus.MustNewConstMetric(
+			cumulativeElectricEnergyDesc,
+			prometheus.CounterValue,
+			v,
+			e.identity.values("normal")...,
+		)
+	}
+
+	if v, ok := e.cumulativeReverse.Value(); ok {
+		ch <- prometheus.MustNewConstMetric(
+			cumulativeElectricEnergyDesc,
+			prometheus.CounterValue,
+			v,
+			e.identity.values("reverse")...,
+		)
+	}
+
+	if v, ok := e.fixedTimeNormal.Value(); ok {
+		ch <- prometheus.NewMetricWithTimestamp(e.fixedTimeNormalAt, prometheus.MustNewConstMetric(
+			fixedTimeCumulativeElectricEnergyDesc,
+			prometheus.CounterValue,
+			v,
+			e.identity.values("normal")...,
+		))
+	}
+
+	if v, ok := e.fixedTimeReverse.Value(); ok {
+		ch <- prometheus.NewMetricWithTimestamp(e.fixedTimeReverseAt, prometheus.MustNewConstMetric(
+			fixedTimeCumulativeElectricEnergyDesc,
+			prometheus.CounterValue,
+			v,
+			e.identity.values("reverse")...,
+		))
+	}
+}
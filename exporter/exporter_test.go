@@ -0,0 +1,187 @@
+package exporter
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property/smartmeter"
+)
+
+// メトリクス名と direction か phase のラベルごとの値を返します。
+func gather(t *testing.T, e *Exporter) map[string]float64 {
+	t.Helper()
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	values := map[string]float64{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			key := mf.GetName()
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "direction" || l.GetName() == "phase" {
+					key += "/" + l.GetValue()
+				}
+			}
+			values[key] = value(m)
+		}
+	}
+	return values
+}
+
+func value(m *dto.Metric) float64 {
+	if m.GetCounter() != nil {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetGauge().GetValue()
+}
+
+// 有効桁数で 0 に戻った積算電力量も単調増加のカウンターとして出力する
+func TestCumulativeElectricEnergyRollover(t *testing.T) {
+	const name = "smartmeter_cumulative_electric_energy_kilowatt_hours_total/normal"
+
+	tests := []struct {
+		name  string
+		scale smartmeter.Scale
+		raws  []uint32
+		want  float64
+	}{
+		{"rollover at the modulus", smartmeter.Scale{Coefficient: 1, Unit: 0.1, EffectiveDigits: 6}, []uint32{999999, 3}, 100000.3},
+		{"jitter", smartmeter.Scale{Coefficient: 1, Unit: 0.1, EffectiveDigits: 6}, []uint32{500000, 499999}, 50000},
+		{"half the modulus", smartmeter.Scale{Coefficient: 1, Unit: 1, EffectiveDigits: 6}, []uint32{500000, 0}, 500000},
+		{"over half the modulus", smartmeter.Scale{Coefficient: 1, Unit: 1, EffectiveDigits: 6}, []uint32{500001, 0}, 1000000},
+		{"unit 0.0001", smartmeter.Scale{Coefficient: 1, Unit: 0.0001, EffectiveDigits: 8}, []uint32{99999999, 1}, 10000.0001},
+		{"unit 10000", smartmeter.Scale{Coefficient: 2, Unit: 10000, EffectiveDigits: 1}, []uint32{9, 1}, 220000},
+	}
+	for _, tt := range tests {
+		e := New()
+		e.SetScale(&tt.scale)
+		for _, raw := range tt.raws {
+			if !e.Update(&smartmeter.MeasuredCumulativeAmountOfElectricEnergyNormalDirection{Value: raw}) {
+				t.Errorf("%s: Update(%d) = false", tt.name, raw)
+			}
+		}
+
+		got, ok := gather(t, e)[name]
+		if !ok {
+			t.Errorf("%s: %s not found", tt.name, name)
+			continue
+		}
+		if math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%s: %s = %v, want %v", tt.name, name, got, tt.want)
+		}
+	}
+}
+
+// 換算値が変わった場合は桁あふれの補正をやり直し、同じ値なら維持する
+func TestSetScaleResetsRollover(t *testing.T) {
+	const name = "smartmeter_cumulative_electric_energy_kilowatt_hours_total/normal"
+
+	e := New()
+	e.SetScale(&smartmeter.Scale{Coefficient: 1, Unit: 1, EffectiveDigits: 2})
+	e.Update(&smartmeter.MeasuredCumulativeAmountOfElectricEnergyNormalDirection{Value: 99})
+	e.Update(&smartmeter.MeasuredCumulativeAmountOfElectricEnergyNormalDirection{Value: 1})
+
+	e.SetScale(&smartmeter.Scale{Coefficient: 1, Unit: 1, EffectiveDigits: 2})
+	if got := gather(t, e)[name]; got != 101 {
+		t.Errorf("%s after the same scale = %v, want 101", name, got)
+	}
+
+	e.SetScale(&smartmeter.Scale{Coefficient: 1, Unit: 0.1, EffectiveDigits: 2})
+	if _, ok := gather(t, e)[name]; ok {
+		t.Errorf("%s exported before a value for the new scale", name)
+	}
+	e.Update(&smartmeter.MeasuredCumulativeAmountOfElectricEnergyNormalDirection{Value: 5})
+	if got := gather(t, e)[name]; math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("%s after a new scale = %v, want 0.5", name, got)
+	}
+}
+
+// 対象外のプロパティや換算できない値は記録せず false を返す
+func TestUpdateIgnored(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		name  string
+		scale *smartmeter.Scale
+		p     property.Property
+	}{
+		{"unknown EPC", nil, property.NewUnknownProperty(property.RawProperty{EPC: 0xF0, EDT: []uint8{0x01}})},
+		{"coefficient", nil, &smartmeter.Coefficient{Value: 1}},
+		{"route B identification number", nil, &smartmeter.RouteBIdentificationNumber{ManufacturerCode: "000016"}},
+		{"cumulative energy without scale", nil, &smartmeter.MeasuredCumulativeAmountOfElectricEnergyNormalDirection{Value: 1}},
+		{"fixed time without scale", nil, &smartmeter.CumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection{MeasuredAt: at, Value: 1}},
+		// 計測値が未取得
+		{"fixed time not measured", &smartmeter.Scale{Coefficient: 1, Unit: 1, EffectiveDigits: 6}, &smartmeter.CumulativeAmountOfElectricEnergyMeasuredAtFixedTimeReverseDirection{MeasuredAt: at, Value: 0xFFFFFFFE}},
+	}
+	for _, tt := range tests {
+		e := New()
+		if tt.scale != nil {
+			e.SetScale(tt.scale)
+		}
+		if e.Update(tt.p) {
+			t.Errorf("%s: Update = true, want false", tt.name)
+		}
+		if got := gather(t, e); len(got) != 0 {
+			t.Errorf("%s: exported %v, want nothing", tt.name, got)
+		}
+	}
+}
+
+// 定時積算電力量は計測日時が進んだときだけ更新する
+func TestFixedTimeCumulativeElectricEnergyStale(t *testing.T) {
+	const name = "smartmeter_fixed_time_cumulative_electric_energy_kilowatt_hours_total/normal"
+	at := time.Date(2024, 1, 1, 0, 30, 0, 0, time.Local)
+
+	e := New()
+	e.SetScale(&smartmeter.Scale{Coefficient: 1, Unit: 1, EffectiveDigits: 6})
+	if !e.Update(&smartmeter.CumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection{MeasuredAt: at, Value: 10}) {
+		t.Fatal("Update = false, want true")
+	}
+	for _, old := range []time.Time{at, at.Add(-30 * time.Minute)} {
+		if e.Update(&smartmeter.CumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection{MeasuredAt: old, Value: 20}) {
+			t.Errorf("Update measured at %v = true, want false", old)
+		}
+	}
+	if got := gather(t, e)[name]; got != 10 {
+		t.Errorf("%s = %v, want 10", name, got)
+	}
+
+	if !e.Update(&smartmeter.CumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection{MeasuredAt: at.Add(30 * time.Minute), Value: 20}) {
+		t.Error("Update of a newer measurement = false, want true")
+	}
+	if got := gather(t, e)[name]; got != 20 {
+		t.Errorf("%s = %v, want 20", name, got)
+	}
+}
+
+// 識別情報が変わると、以前のラベルのメトリクスは出力しない
+func TestSetIdentityRemovesOldSeries(t *testing.T) {
+	e := New()
+	e.SetIdentity(Identity{ManufacturerCode: "000016", ID: "0001", Addr: "FE80::1"})
+	e.Update(&smartmeter.MeasuredInstantaneousElectricPower{Value: 900})
+	e.SetIdentity(Identity{ManufacturerCode: "000016", ID: "0002", Addr: "FE80::2"})
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	if len(mfs) != 1 || len(mfs[0].GetMetric()) != 1 {
+		t.Fatalf("gathered %v, want one series", mfs)
+	}
+	for _, l := range mfs[0].GetMetric()[0].GetLabel() {
+		if l.GetName() == "addr" && l.GetValue() != "FE80::2" {
+			t.Errorf("addr = %s, want FE80::2", l.GetValue())
+		}
+	}
+}
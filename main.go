@@ -2,24 +2,235 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/config"
 	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite"
 	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property"
 	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property/smartmeter"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/exporter"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
 	"github.com/rokoucha/akizuki-dg-route-b-exporter/serial"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/simulator"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/supervisor"
 )
 
 type options struct {
-	BaudRate *uint `short:"b" long:"baud-rate" description:"Baud rate to connect to Wi-SUN module, default: 115200"`
-	Scan     *bool `short:"s" long:"scan" description:"Scan for available PANs"`
-	Verbose  *bool `short:"v" long:"verbose" description:"Show verbose debug information"`
+	BaudRate      *int           `short:"b" long:"baud-rate" description:"Baud rate to connect to Wi-SUN module, default: 115200"`
+	Capture       *string        `long:"capture" description:"Record all serial traffic to this file (mode 0600, secrets redacted but still sensitive), replay it with replay://<file> as the serial port"`
+	Config        *string        `short:"c" long:"config" description:"Path to the YAML config file"`
+	EPCs          []string       `short:"e" long:"epc" description:"EPC to request on each poll in hex, can be repeated, default: E7, E8, E0, E3"`
+	ListenAddress *string        `short:"l" long:"listen-address" description:"Address to listen on for metrics, default: :9888"`
+	Module        *string        `long:"module" description:"Wi-SUN module variant (auto, mb-rl7023-11, bp35a1, bp35c0), default: mb-rl7023-11"`
+	PollInterval  *time.Duration `short:"i" long:"poll-interval" description:"Interval between polls, default: 1m"`
+	Scan          *bool          `short:"s" long:"scan" description:"Scan for available PANs"`
+	Simulate      *bool          `long:"simulate" description:"Use the built-in Wi-SUN module simulator instead of a serial port"`
+	StateFile     *string        `long:"state-file" description:"File to cache the discovered PAN in, default: $XDG_CACHE_HOME/akizuki-dg-route-b-exporter/pan.json"`
+	Survey        *bool          `long:"survey" description:"Repeat ED scans on channels 21-3C and report RSSI per channel"`
+	SurveyFormat  *string        `long:"survey-format" choice:"table" choice:"json" description:"Output format of the survey, default: table"`
+	SurveyRounds  *int           `long:"survey-rounds" description:"Number of ED scans in the survey, 0 runs until interrupted, default: 10"`
+	Verbose       *bool          `short:"v" long:"verbose" description:"Show verbose debug information"`
+}
+
+// 設定ファイル、環境変数、フラグの順に読み込み、後のものほど優先します。
+func loadConfig(opts options, args []string) (*config.Config, error) {
+	cfg := config.Default()
+
+	if opts.Config != nil {
+		err := cfg.LoadFile(*opts.Config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := cfg.LoadEnv(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) > 0 {
+		cfg.Serial.Port = args[0]
+	}
+	if opts.BaudRate != nil {
+		cfg.Serial.BaudRate = *opts.BaudRate
+	}
+	if len(opts.EPCs) > 0 {
+		cfg.Poll.EPCs = opts.EPCs
+	}
+	if opts.ListenAddress != nil {
+		cfg.Metrics.ListenAddress = *opts.ListenAddress
+	}
+	if opts.PollInterval != nil {
+		cfg.Poll.Interval = *opts.PollInterval
+	}
+	if opts.Capture != nil {
+		cfg.Serial.Capture = *opts.Capture
+	}
+	if opts.Module != nil {
+		cfg.Serial.Module = *opts.Module
+	}
+	if opts.Simulate != nil {
+		cfg.Serial.Simulate = *opts.Simulate
+	}
+	if opts.StateFile != nil {
+		cfg.PAN.StateFile = *opts.StateFile
+	}
+	if opts.Verbose != nil {
+		cfg.Log.Verbose = *opts.Verbose
+	}
+
+	if cfg.PAN.StateFile == "" {
+		cfg.PAN.StateFile = defaultStateFile()
+	}
+
+	if opts.Survey != nil && *opts.Survey {
+		return cfg, cfg.ValidateSurvey()
+	}
+	return cfg, cfg.Validate()
+}
+
+var errUnexpectedResponse = errors.New("unexpected response")
+
+// ECHONET Lite のトランザクション ID、下位 16 ビットを使う
+var tid atomic.Uint32
+
+// 指定した EPC を 1 つの Get 要求でまとめて取得します。
+// 不可応答の場合は値が返ってきたプロパティと、取得できなかった EPC をそれぞれ返します。
+func get(ctx context.Context, mb module.Module, addr string, epcs ...property.EPC) ([]property.Property, []property.EPC, error) {
+	props := make([]property.Property, len(epcs))
+	for i, epc := range epcs {
+		props[i] = property.NewUnknownProperty(property.RawProperty{EPC: epc, EDT: []uint8{}})
+	}
+
+	id := uint16(tid.Add(1))
+	frame := &echonetlite.Frame{
+		EHD1: echonetlite.EHD1ECHONETLite,
+		EHD2: echonetlite.EHD2SpecifiedMessageFormat,
+		TID:  [2]uint8{uint8(id >> 8), uint8(id)},
+		EDATA: echonetlite.Data{
+			SEOJ:       [3]uint8{0x05, 0xff, 0x01},
+			DEOJ:       [3]uint8{0x02, 0x88, 0x01},
+			ESV:        echonetlite.ESVGet,
+			Properties: props,
+		},
+	}
+	received, err := mb.SKSENDTO(ctx, 0x01, addr, 0x0E1A, MB_RL7023_11.SKSENDTOSecStrict, MB_RL7023_11.SKSENDTOReservedValue, frame.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e, err := echonetlite.NewFrame(received.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !frame.IsPairFrame(e) {
+		return nil, nil, errUnexpectedResponse
+	}
+
+	switch e.EDATA.ESV {
+	case echonetlite.ESVGet_Res, echonetlite.ESVGet_SNA:
+	default:
+		return nil, nil, errUnexpectedResponse
+	}
+
+	var available []property.Property
+	var unavailable []property.EPC
+	for _, p := range e.EDATA.Properties {
+		if u, ok := p.(*property.UnknownProperty); ok && len(u.EDT) == 0 {
+			unavailable = append(unavailable, u.EPC)
+			continue
+		}
+		available = append(available, p)
+	}
+
+	return available, unavailable, nil
+}
+
+const (
+	notificationBufferSize = 16
+	// 終了時に SKTERM を待つ時間
+	termTimeout = 5 * time.Second
+)
+
+// キャプチャーファイルを作ります。
+// 認証情報は伏せても、メーターの識別番号や計測値などが残るので、所有者だけが読めるようにします。
+func createCapture(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	// 既にあったファイルの権限は OpenFile では変わらない
+	err = f.Chmod(0o600)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// 名前からモジュールの種類を選びます。name が auto の場合は SKVER から推測します。
+func selectVariant(ctx context.Context, mb *MB_RL7023_11.MB_RL7023_11, name string) (module.Variant, error) {
+	if name == "auto" {
+		return module.Detect(ctx, mb)
+	}
+	return module.Lookup(name)
+}
+
+// インターバルとフロー制御はそのままで、モジュールとホストのボーレートを変更します。
+func setBaudRate(ctx context.Context, mb *MB_RL7023_11.MB_RL7023_11, baudRate int) error {
+	mode, err := mb.RUART(ctx)
+	if err != nil {
+		return fmt.Errorf("RUART: %w", err)
+	}
+	mode, err = mode.WithBaudRate(baudRate)
+	if err != nil {
+		return err
+	}
+	return mb.SetUART(ctx, mode)
+}
+
+func serveMetrics(addr string, logger *slog.Logger) {
+	http.Handle("/metrics", promhttp.Handler())
+	logger.Info("Listening for metrics", "addr", addr)
+	err := http.ListenAndServe(addr, nil)
+	if err != nil {
+		logger.Error("Failed to serve metrics", "err", err)
+		os.Exit(1)
+	}
+}
+
+// メーターからの通知を exporter に反映します。Get 要求への応答はポーリング側で処理するため無視します。
+func handleNotification(u *MB_RL7023_11.ERXUDP, exp *exporter.Exporter, logger *slog.Logger) {
+	f, err := echonetlite.NewFrame(u.Data)
+	if err != nil {
+		return
+	}
+	logger.Debug("Received frame", "frame", f)
+
+	if f.EDATA.ESV != echonetlite.ESVINF && f.EDATA.ESV != echonetlite.ESVINFC {
+		return
+	}
+	for _, p := range f.EDATA.Properties {
+		if !exp.Update(p) {
+			logger.Debug("Ignored notified property", "property", p)
+			continue
+		}
+		logger.Info("Notified property", "property", p)
+	}
 }
 
 func main() {
@@ -36,25 +247,25 @@ func main() {
 		os.Exit(2)
 	}
 
+	if len(args) > 1 {
+		slog.Error("Please specify only one serial port")
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(opts, args)
+	if err != nil {
+		slog.Error("Failed to load config", "err", err)
+		os.Exit(2)
+	}
+
 	logLevel := slog.LevelInfo
-	if opts.Verbose != nil && *opts.Verbose {
+	if cfg.Log.Verbose {
 		logLevel = slog.LevelDebug
 	}
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
 
-	if len(args) != 1 {
-		logger.Error("Please specify a serial port")
-		os.Exit(1)
-	}
-	portName := args[0]
-
-	var baudrate uint
-	if opts.BaudRate != nil {
-		baudrate = *opts.BaudRate
-	} else {
-		baudrate = 115200
-	}
+	epcs, _ := cfg.Poll.ParseEPCs()
 
 	var scanMode bool
 	if opts.Scan != nil {
@@ -63,44 +274,82 @@ func main() {
 		scanMode = false
 	}
 
-	serial, err := serial.New(serial.Config{
-		PortName: portName,
-		BaudRate: int(baudrate),
-	})
-	if err != nil {
-		logger.Error("Failed to open serial port", "err", err)
-		os.Exit(1)
+	surveyMode := opts.Survey != nil && *opts.Survey
+	surveyFormat := "table"
+	if opts.SurveyFormat != nil {
+		surveyFormat = *opts.SurveyFormat
+	}
+	surveyRounds := 10
+	if opts.SurveyRounds != nil {
+		surveyRounds = *opts.SurveyRounds
+	}
+	if surveyRounds < 0 {
+		slog.Error("Survey rounds must not be negative", "rounds", surveyRounds)
+		os.Exit(2)
+	}
+
+	var port io.ReadWriteCloser
+	var reopen func() (io.ReadWriteCloser, error)
+	if cfg.Serial.Simulate {
+		logger.Info("Using Wi-SUN module simulator")
+		port = simulator.New(simulator.Config{NotifyInterval: cfg.Poll.Interval * 5})
+	} else {
+		reopen = serial.Opener(cfg.Serial.Port, cfg.Serial.BaudRate)
+		port, err = reopen()
+		if err != nil {
+			logger.Error("Failed to open serial port", "err", err)
+			os.Exit(1)
+		}
+	}
+	if cfg.Serial.Capture != "" {
+		f, err := createCapture(cfg.Serial.Capture)
+		if err != nil {
+			logger.Error("Failed to create capture file", "err", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		logger.Info("Recording serial traffic", "file", cfg.Serial.Capture)
+
+		port = serial.Record(port, f, MB_RL7023_11.RedactLine)
+		if open := reopen; open != nil {
+			reopen = func() (io.ReadWriteCloser, error) {
+				p, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return serial.Record(p, f, MB_RL7023_11.RedactLine), nil
+			}
+		}
 	}
-	closer := func() {
+	serial := serial.New(serial.Config{
+		Logger: logger,
+		Port:   port,
+		Reopen: reopen,
+	})
+	// 後からシグナルを待つ goroutine と並行して差し替えるので atomic に持つ
+	var closer atomic.Pointer[func()]
+	closeSerial := func() {
 		serial.Close()
 	}
-	defer func() {
-		closer()
-	}()
+	closer.Store(&closeSerial)
+	// シグナルと main の終了の両方から呼ばれるので、SKTERM を重ねて送らないよう一度だけ実行する
+	var closeOnce sync.Once
+	closeFn := func() {
+		(*closer.Load())()
+	}
+	defer closeOnce.Do(closeFn)
 	go func() {
 		<-ctx.Done()
-		closer()
+		closeOnce.Do(closeFn)
 	}()
 
+	exp := exporter.New()
+	prometheus.MustRegister(exp)
+
 	listener := func(lines []string) error {
 		for _, line := range lines {
 			logger.Debug("streaming", "line", line)
 		}
-		events := MB_RL7023_11.ParseEvent(lines)
-		for _, event := range events {
-			u, ok := event.(*MB_RL7023_11.ERXUDP)
-			if !ok {
-				continue
-			}
-			f, err := echonetlite.NewFrame(u.Data)
-			if err != nil {
-				continue
-			}
-			logger.Info("Received frame", "frame", f)
-			for _, p := range f.EDATA.Properties {
-				logger.Info("Property", "property", p)
-			}
-		}
 		return nil
 	}
 
@@ -108,72 +357,167 @@ func main() {
 
 	ready := make(chan struct{})
 	errCh := make(chan error)
-	go serial.Streaming(ctx, ready, errCh)
+	// 終了時の SKTERM の応答を受け取れるよう、読み込みは serial.Close まで続ける
+	go serial.Streaming(context.WithoutCancel(ctx), ready, errCh)
 	<-ready
+	go func() {
+		err := <-errCh
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			// キャプチャーの再生が終わった
+			logger.Info("End of serial input")
+		} else {
+			logger.Error("Streaming stopped", "err", err)
+		}
+		stop()
+	}()
 
 	mb := MB_RL7023_11.New(MB_RL7023_11.Config{
-		Logger: logger,
-		Serial: serial,
+		Logger:    logger,
+		Transport: serial,
 	})
+
+	go func() {
+		sub := mb.SubscribeERXUDP(notificationBufferSize)
+		defer sub.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u := <-sub.C:
+				handleNotification(u, exp, logger)
+			}
+		}
+	}()
+	prometheus.MustRegister(prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Namespace: "smartmeter",
+			Name:      "module_events_dropped_total",
+			Help:      "Number of events from the Wi-SUN module dropped because a subscriber was too slow.",
+		},
+		func() float64 {
+			return float64(mb.Dropped())
+		},
+	))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "smartmeter",
+			Name:      "module_transmit_limited",
+			Help:      "Whether the Wi-SUN module is holding transmissions because the hourly transmit time limit was exceeded.",
+		},
+		func() float64 {
+			if mb.TransmitLimited() {
+				return 1
+			}
+			return 0
+		},
+	))
+	transmit, err := newTransmitMetrics(prometheus.DefaultRegisterer)
+	if err != nil {
+		logger.Error("Failed to register transmit time metrics", "err", err)
+		os.Exit(1)
+	}
+	baudRate, err := mb.ProbeBaudRate(ctx, cfg.Serial.BaudRate)
+	switch {
+	case errors.Is(err, MB_RL7023_11.ErrBaudRateUnsupported):
+		// ser2net などはホスト側のボーレートを変えられないので探さず、初期化で応答を確かめる
+		logger.Warn("Baud rate probe is not applicable to this transport, using the configured baud rate", "baud_rate", cfg.Serial.BaudRate)
+		baudRate = cfg.Serial.BaudRate
+	case err != nil:
+		logger.Error("Wi-SUN module not responding", "err", err)
+		os.Exit(1)
+	}
+	if baudRate != cfg.Serial.BaudRate {
+		// 以前の設定が残っているモジュールを設定したボーレートに戻す
+		logger.Warn("Wi-SUN module found at a different baud rate", "baud_rate", baudRate)
+		err := setBaudRate(ctx, mb, cfg.Serial.BaudRate)
+		if err != nil {
+			logger.Warn("Failed to change baud rate of Wi-SUN module", "err", err)
+		}
+	}
+
 	err = mb.Initialize(ctx)
 	if err != nil {
 		logger.Error("Failed to initialize Wi-SUN module", "err", err)
 		os.Exit(1)
 	}
 
-	ver, err := mb.SKVER(ctx)
+	variant, err := selectVariant(ctx, mb, cfg.Serial.Module)
+	if err != nil {
+		logger.Error("Failed to detect Wi-SUN module variant", "err", err)
+		os.Exit(1)
+	}
+	mod := module.New(variant, mb)
+
+	ver, err := mod.SKVER(ctx)
 	if err != nil {
 		logger.Error("Failed to execute command: SKVER", "err", err)
 		os.Exit(1)
 	}
-	info, err := mb.SKINFO(ctx)
+	info, err := mod.SKINFO(ctx)
 	if err != nil {
 		logger.Error("Failed to execute command: SKINFO", "err", err)
 		os.Exit(1)
 	}
-	logger.Info("Wi-SUN module info", "version", ver, "info", info)
+	logger.Info("Wi-SUN module info", "variant", variant.Name, "version", ver, "info", info)
 
-	rbid := os.Getenv("ROUTE_B_ID")
-	if rbid == "" {
-		logger.Error("Please set ROUTE_B_ID env variable")
-		os.Exit(1)
+	if surveyMode {
+		result := newSurvey()
+		if cfg.Metrics.Enabled {
+			err := result.register(prometheus.DefaultRegisterer)
+			if err != nil {
+				logger.Error("Failed to register survey metrics", "err", err)
+				os.Exit(1)
+			}
+			go serveMetrics(cfg.Metrics.ListenAddress, logger)
+		}
+
+		err := result.run(ctx, mod, surveyRounds, logger)
+		if err != nil {
+			logger.Error("Failed to execute command: SKSCAN", "err", err)
+			os.Exit(1)
+		}
+
+		if surveyFormat == "json" {
+			err = result.writeJSON(os.Stdout)
+		} else {
+			err = result.writeTable(os.Stdout)
+		}
+		if err != nil {
+			logger.Error("Failed to write survey", "err", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	err = mb.SKSETRBID(ctx, rbid)
+	err = mod.SKSETRBID(ctx, cfg.RouteB.ID)
 	if err != nil {
 		logger.Error("Failed to execute command: SKSETRBID", "err", err)
 		os.Exit(1)
 	}
 
-	rbpwd := os.Getenv("ROUTE_B_PASSWORD")
-	if rbpwd == "" {
-		logger.Error("Please set ROUTE_B_PASSWORD env variable")
-		os.Exit(1)
-	}
-
-	err = mb.SKSETPWD(ctx, rbpwd)
+	err = mod.SKSETPWD(ctx, cfg.RouteB.Password)
 	if err != nil {
 		logger.Error("Failed to execute command: SKSETPWD", "err", err)
 		os.Exit(1)
 	}
 
 	if scanMode {
-		res, err := mb.SKSCAN(ctx, MB_RL7023_11.SKSCANModeActiveWithIE, 0xFFFFFFFF, 6, MB_RL7023_11.SKSCANReservedValue)
+		pans, err := scanPANs(ctx, mod)
 		if err != nil {
 			logger.Error("Failed to execute command: SKSCAN", "err", err)
 			os.Exit(1)
 		}
-		var pans []*MB_RL7023_11.EPANDESC
-		for _, pan := range res {
-			pans = append(pans, pan.(*MB_RL7023_11.EPANDESC))
-		}
 		if len(pans) == 0 {
 			logger.Info("No PANs found")
 			os.Exit(0)
 		}
 		logger.Info("Found PANs")
 		for i, pan := range pans {
-			ipaddr, err := mb.SKLL64(ctx, pan.Addr)
+			ipaddr, err := mod.SKLL64(ctx, pan.Addr)
 			if err != nil {
 				logger.Error("Failed to execute command: SKLL64", "err", err)
 				os.Exit(1)
@@ -193,86 +537,222 @@ func main() {
 		os.Exit(0)
 	}
 
-	channel := os.Getenv("ROUTE_B_CHANNEL")
-	if channel == "" {
-		logger.Error("Please set ROUTE_B_CHANNEL env variable")
-		os.Exit(1)
+	// 接続先が指定されていない場合はスキャンして探し、結果を保存しておく
+	var target *panTarget
+	fixed := cfg.PAN.IsFixed()
+	if fixed {
+		target = &panTarget{Channel: *cfg.PAN.Channel, PanID: *cfg.PAN.PanID, Addr: cfg.PAN.Addr}
+	} else {
+		target, err = loadPAN(cfg.PAN.StateFile)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				logger.Warn("Failed to load cached PAN", "path", cfg.PAN.StateFile, "err", err)
+			}
+			target = nil
+		} else {
+			logger.Info("Loaded cached PAN", "path", cfg.PAN.StateFile, "pan", target)
+		}
 	}
 
-	_, err = mb.SKSREG(ctx, MB_RL7023_11.RegisterChannel, channel)
-	if err != nil {
-		logger.Error("Failed to execute command: SKSREG", "err", err)
-		os.Exit(1)
-	}
+	sv := supervisor.New(supervisor.Config{
+		Join: func(ctx context.Context) error {
+			err := mod.SKSETRBID(ctx, cfg.RouteB.ID)
+			if err != nil {
+				return fmt.Errorf("SKSETRBID: %w", err)
+			}
+			err = mod.SKSETPWD(ctx, cfg.RouteB.Password)
+			if err != nil {
+				return fmt.Errorf("SKSETPWD: %w", err)
+			}
 
-	panid := os.Getenv("ROUTE_B_PANID")
-	if panid == "" {
-		logger.Error("Please set ROUTE_B_PANID env variable")
-		os.Exit(1)
-	}
+			if target == nil {
+				logger.Info("Scanning for PAN", "pairid", cfg.PAN.PairID)
+				target, err = discoverPAN(ctx, mod, cfg.PAN.PairID)
+				if err != nil {
+					return err
+				}
+				logger.Info("Found PAN", "pan", target)
+
+				err = savePAN(cfg.PAN.StateFile, target)
+				if err != nil {
+					logger.Warn("Failed to cache PAN", "path", cfg.PAN.StateFile, "err", err)
+				}
+			}
 
-	_, err = mb.SKSREG(ctx, MB_RL7023_11.RegisterPANID, panid)
-	if err != nil {
-		logger.Error("Failed to execute command: SKSREG", "err", err)
-		os.Exit(1)
+			err = mod.SetChannel(ctx, target.Channel)
+			if err != nil {
+				return fmt.Errorf("SKSREG: %w", err)
+			}
+			err = mod.SetPANID(ctx, target.PanID)
+			if err != nil {
+				return fmt.Errorf("SKSREG: %w", err)
+			}
+
+			logger.Info("Joining to PAN", "addr", target.Addr)
+			err = mod.SKJOIN(ctx, target.Addr)
+			if err != nil {
+				// 保存していた PAN に参加できない場合は次回スキャンし直す
+				if !fixed {
+					target = nil
+				}
+				return fmt.Errorf("SKJOIN: %w", err)
+			}
+			return nil
+		},
+		Logger: logger,
+		Module: mod,
+		OnSessionChange: func(c MB_RL7023_11.SessionStateChange) {
+			logger.Info("PANA session state changed", "from", c.From, "to", c.To)
+		},
+	})
+	go sv.Watch(ctx)
+
+	prometheus.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Namespace: "smartmeter",
+			Name:      "up",
+			Help:      "Whether the PANA session to the smart meter is established.",
+		},
+		func() float64 {
+			if sv.State() == supervisor.StateConnected {
+				return 1
+			}
+			return 0
+		},
+	))
+
+	if cfg.Metrics.Enabled {
+		go serveMetrics(cfg.Metrics.ListenAddress, logger)
 	}
 
-	addr := os.Getenv("ROUTE_B_ADDR")
-	if addr == "" {
-		logger.Error("Please set ROUTE_B_ADDR env variable")
-		os.Exit(1)
+	term := func() {
+		if mod.Session().State == MB_RL7023_11.SessionStateConnected {
+			ctx, cancel := context.WithTimeout(context.Background(), termTimeout)
+			defer cancel()
+			err := mod.SKTERM(ctx)
+			if err != nil {
+				logger.Error("Failed to execute command: SKTERM", "err", err)
+			} else {
+				logger.Info("Disconnected from", "addr", target.Addr)
+			}
+		}
+
+		serial.Close()
 	}
+	closer.Store(&term)
 
-	logger.Info("Joining to PAN", "addr", addr)
-	err = mb.SKJOIN(ctx, addr)
+	err = sv.Connect(ctx)
 	if err != nil {
-		logger.Error("Failed to execute command: SKJOIN", "err", err)
+		logger.Error("Failed to join to PAN", "err", err)
 		os.Exit(1)
 	}
 
-	closer = func() {
-		ctx := context.Background()
-		err = mb.SKTERM(ctx)
-		if err != nil {
-			logger.Error("Failed to execute command: SKTERM", "err", err)
-			os.Exit(1)
+	fresh := true
+	for {
+		// ここで状態を確かめるので、それまでの通知は読み捨てる
+		select {
+		case <-sv.Lost():
+		default:
+		}
+		if sv.State() != supervisor.StateConnected {
+			err := sv.Recover(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to recover session", "err", err)
+				os.Exit(1)
+			}
+			fresh = true
 		}
-		logger.Info("Disconnected from", "addr", addr)
 
-		serial.Close()
-	}
+		// セッションごとに識別番号と積算電力量の換算値を読み直す
+		if fresh {
+			err := readMeterInfo(ctx, mod, target.Addr, exp, logger)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to read smart meter information", "err", err)
+				sv.Disconnected()
+				continue
+			}
+			fresh = false
+		}
 
-	for {
-		logger.Info("Send command frame")
-
-		frame := &echonetlite.Frame{
-			EHD1: echonetlite.EHD1ECHONETLite,
-			EHD2: echonetlite.EHD2SpecifiedMessageFormat,
-			TID:  [2]uint8{0x00, 0x01},
-			EDATA: echonetlite.Data{
-				SEOJ: [3]uint8{0x05, 0xff, 0x01},
-				DEOJ: [3]uint8{0x02, 0x88, 0x01},
-				ESV:  echonetlite.ESVGet,
-				Properties: []property.Property{
-					&smartmeter.MeasuredInstantaneousElectricPower{},
-				},
-			},
+		received, unavailable, err := get(ctx, mod, target.Addr, epcs...)
+		if ctx.Err() != nil {
+			return
 		}
-		received, err := mb.SKSENDTO(ctx, 0x01, addr, 0x0E1A, MB_RL7023_11.SKSENDTOSecStrict, MB_RL7023_11.SKSENDTOReservedValue, frame.Bytes())
-		if err != nil {
-			logger.Error("Failed to execute command: SKSENDTO", "err", err)
-			os.Exit(1)
+		if errors.Is(err, errUnexpectedResponse) {
+			logger.Warn("Ignored unexpected response", "err", err)
+		} else if err != nil {
+			logger.Error("Failed to get properties", "err", err)
+			sv.Disconnected()
+			continue
+		}
+		if len(unavailable) > 0 {
+			logger.Warn("Some properties are unavailable", "epcs", unavailable)
 		}
 
-		e, err := echonetlite.NewFrame(received.Data)
-		if err != nil {
-			logger.Error("Failed to parse packet", "err", err)
-			os.Exit(1)
+		for _, p := range received {
+			if !exp.Update(p) {
+				logger.Debug("Ignored property", "property", p)
+				continue
+			}
+			logger.Info("Property", "property", p)
+		}
+		transmit.sample(ctx, mod, logger)
+
+		// セッションが失われたら次の取得を待たずに復旧する
+		select {
+		case <-ctx.Done():
+			return
+		case <-sv.Lost():
+		case <-time.After(cfg.Poll.Interval):
 		}
+	}
+}
+
+// B ルート識別番号と積算電力量の換算に必要なプロパティを読み込みます。
+func readMeterInfo(ctx context.Context, mb module.Module, addr string, exp *exporter.Exporter, logger *slog.Logger) error {
+	meterInfo, unavailable, err := get(ctx, mb, addr,
+		smartmeter.EPCRouteBIdentificationNumber,
+		smartmeter.EPCCoefficient,
+		smartmeter.EPCNumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy,
+		smartmeter.EPCUnitForCumulativeAmountOfElectricEnergy,
+	)
+	if err != nil {
+		return err
+	}
+	if len(unavailable) > 0 {
+		logger.Warn("Some smart meter properties are unavailable", "epcs", unavailable)
+	}
 
-		kw := e.EDATA.Properties[0].(*smartmeter.MeasuredInstantaneousElectricPower).Value
-		logger.Info("Instantaneous power measurement value", "kw", kw)
+	var rbidn *smartmeter.RouteBIdentificationNumber
+	var c *smartmeter.Coefficient
+	var d *smartmeter.NumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy
+	var u *smartmeter.UnitForCumulativeAmountOfElectricEnergy
+	for _, p := range meterInfo {
+		switch v := p.(type) {
+		case *smartmeter.RouteBIdentificationNumber:
+			rbidn = v
+		case *smartmeter.Coefficient:
+			c = v
+		case *smartmeter.NumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy:
+			d = v
+		case *smartmeter.UnitForCumulativeAmountOfElectricEnergy:
+			u = v
+		}
+	}
+	exp.SetIdentity(exporter.NewIdentity(rbidn, addr))
 
-		time.Sleep(60 * time.Second)
+	scale, err := smartmeter.NewScale(c, d, u)
+	if err != nil {
+		return err
 	}
+	exp.SetScale(scale)
+	logger.Info("Cumulative amount of electric energy scale", "coefficient", scale.Coefficient, "unit", scale.Unit, "digits", scale.EffectiveDigits)
+
+	return nil
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property/smartmeter"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/exporter"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/serial"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/simulator"
+)
+
+// シミュレーターを相手に、初期化からスキャン、参加、プロパティの取得、メトリクスへの反映までを通して確かめる
+func TestEndToEnd(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// 瞬時電力が 900W になる時刻に固定する
+	now := time.Unix(1700000400, 0)
+	sim := simulator.New(simulator.Config{Meter: simulator.NewMeter(func() time.Time { return now })})
+	s := serial.New(serial.Config{Logger: logger, Port: sim})
+	defer s.Close()
+
+	ready := make(chan struct{})
+	go s.Streaming(ctx, ready, make(chan error, 1))
+	<-ready
+
+	mb := MB_RL7023_11.New(MB_RL7023_11.Config{Logger: logger, Transport: s})
+	err := mb.Initialize(ctx)
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	mod := module.New(module.MBRL7023_11, mb)
+
+	err = mod.SKSETRBID(ctx, "00112233445566778899AABBCCDDEEFF")
+	if err != nil {
+		t.Fatalf("SKSETRBID: %v", err)
+	}
+	err = mod.SKSETPWD(ctx, "0123456789AB")
+	if err != nil {
+		t.Fatalf("SKSETPWD: %v", err)
+	}
+
+	target, err := discoverPAN(ctx, mod, simulator.PairID)
+	if err != nil {
+		t.Fatalf("discoverPAN: %v", err)
+	}
+	want := panTarget{Channel: simulator.Channel, PanID: simulator.PanID, Addr: simulator.MeterIPAddr}
+	if *target != want {
+		t.Fatalf("discoverPAN = %+v, want %+v", *target, want)
+	}
+
+	err = mod.SetChannel(ctx, target.Channel)
+	if err != nil {
+		t.Fatalf("SetChannel: %v", err)
+	}
+	err = mod.SetPANID(ctx, target.PanID)
+	if err != nil {
+		t.Fatalf("SetPANID: %v", err)
+	}
+	err = mod.SKJOIN(ctx, target.Addr)
+	if err != nil {
+		t.Fatalf("SKJOIN: %v", err)
+	}
+	if state := mod.Session().State; state != MB_RL7023_11.SessionStateConnected {
+		t.Fatalf("session state = %s, want %s", state, MB_RL7023_11.SessionStateConnected)
+	}
+
+	exp := exporter.New()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(exp)
+
+	err = readMeterInfo(ctx, mod, target.Addr, exp, logger)
+	if err != nil {
+		t.Fatalf("readMeterInfo: %v", err)
+	}
+
+	received, unavailable, err := get(ctx, mod, target.Addr,
+		smartmeter.EPCMeasuredInstantaneousElectricPower,
+		smartmeter.EPCMeasuredInstantaneousCurrents,
+		smartmeter.EPCMeasuredCumulativeAmountOfElectricEnergyNormalDirection,
+		smartmeter.EPCMeasuredCumulativeAmountOfElectricEnergyReverseDirection,
+	)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if len(unavailable) > 0 {
+		t.Fatalf("unavailable EPCs: %v", unavailable)
+	}
+	for _, p := range received {
+		if !exp.Update(p) {
+			t.Errorf("Update(%v) = false", p)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{"smartmeter_instantaneous_electric_power_watts", nil, 900},
+		{"smartmeter_instantaneous_current_amperes", map[string]string{"phase": "r"}, 4.5},
+		{"smartmeter_instantaneous_current_amperes", map[string]string{"phase": "t"}, 4.5},
+		{"smartmeter_cumulative_electric_energy_kilowatt_hours_total", map[string]string{"direction": "normal"}, 12345.6},
+		{"smartmeter_cumulative_electric_energy_kilowatt_hours_total", map[string]string{"direction": "reverse"}, 78.9},
+	}
+	for _, tt := range tests {
+		labels := map[string]string{"addr": simulator.MeterIPAddr}
+		for k, v := range tt.labels {
+			labels[k] = v
+		}
+		got, ok := metricValue(t, reg, tt.name, labels)
+		if !ok {
+			t.Errorf("%s%v not found", tt.name, labels)
+			continue
+		}
+		if diff := got - tt.want; diff < -1e-9 || diff > 1e-9 {
+			t.Errorf("%s%v = %v, want %v", tt.name, labels, got, tt.want)
+		}
+	}
+}
+
+// labels をすべて含むメトリクスの値を返します。
+func metricValue(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) (float64, bool) {
+	t.Helper()
+
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if !hasLabels(m, labels) {
+				continue
+			}
+			switch {
+			case m.GetGauge() != nil:
+				return m.GetGauge().GetValue(), true
+			case m.GetCounter() != nil:
+				return m.GetCounter().GetValue(), true
+			}
+		}
+	}
+	return 0, false
+}
+
+func hasLabels(m *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, l := range m.GetLabel() {
+		if v, ok := labels[l.GetName()]; ok {
+			if v != l.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
+
+// SKSCAN が順に results の結果を返すモジュール
+type scanModule struct {
+	module.Module
+	results []scanResult
+	calls   int
+}
+
+type scanResult struct {
+	res []any
+	err error
+}
+
+func (m *scanModule) SKSCAN(ctx context.Context, mode MB_RL7023_11.SKSCANMode, channelMask uint32, duration uint8, reserved MB_RL7023_11.SKSCANReserved) ([]any, error) {
+	r := m.results[min(m.calls, len(m.results)-1)]
+	m.calls++
+	return r.res, r.err
+}
+
+// やり直せるエラーは待ってからやり直し、やり直せないエラーはすぐに返す
+func TestSurveyRetry(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	scan := []any{&MB_RL7023_11.EEDSCAN{Result: []MB_RL7023_11.EEDSCANResult{{Channel: 0x21, RSSI: 0x40}}}}
+	timeout := &MB_RL7023_11.CommandError{Command: "SKSCAN", Err: context.DeadlineExceeded}
+	invalid := &MB_RL7023_11.CommandError{Command: "SKSCAN", Code: MB_RL7023_11.ErrorCodeInvalidParameter, Err: MB_RL7023_11.ErrInvalidParameter}
+
+	mb := &scanModule{results: []scanResult{{err: timeout}, {res: scan}}}
+	s := newSurvey()
+	start := time.Now()
+	err := s.run(context.Background(), mb, 1, logger)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if mb.calls != 2 || s.Rounds != 1 || s.Channels[0].Samples != 1 {
+		t.Errorf("calls = %d, rounds = %d, samples = %d, want 2, 1, 1", mb.calls, s.Rounds, s.Channels[0].Samples)
+	}
+	if elapsed := time.Since(start); elapsed < surveyInitialBackoff {
+		t.Errorf("retried after %v, want at least %v", elapsed, surveyInitialBackoff)
+	}
+
+	mb = &scanModule{results: []scanResult{{err: invalid}}}
+	err = newSurvey().run(context.Background(), mb, 1, logger)
+	if !errors.Is(err, MB_RL7023_11.ErrInvalidParameter) || mb.calls != 1 {
+		t.Errorf("run = %v after %d calls, want ErrInvalidParameter after 1 call", err, mb.calls)
+	}
+
+	mb = &scanModule{results: []scanResult{{res: []any{&MB_RL7023_11.EPANDESC{}}}}}
+	err = newSurvey().run(context.Background(), mb, 1, logger)
+	if !errors.Is(err, MB_RL7023_11.ErrUnexpectedOutput) {
+		t.Errorf("run = %v, want ErrUnexpectedOutput", err)
+	}
+}
+
+// 既にあるファイルでも、所有者だけが読み書きできる権限で作り直す
+func TestCreateCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	err := os.WriteFile(path, []uint8("old"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createCapture(path)
+	if err != nil {
+		t.Fatalf("createCapture: %v", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0o600 {
+		t.Errorf("mode = %v, want 0600", mode)
+	}
+	if fi.Size() != 0 {
+		t.Errorf("size = %d, want truncated", fi.Size())
+	}
+}
+
+// TransmitUsage が順に usages を返すモジュール
+type usageModule struct {
+	module.Module
+	usages []MB_RL7023_11.TransmitUsage
+	calls  int
+}
+
+func (m *usageModule) TransmitUsage(ctx context.Context) (MB_RL7023_11.TransmitUsage, error) {
+	u := m.usages[min(m.calls, len(m.usages)-1)]
+	m.calls++
+	return u, nil
+}
+
+func TestTransmitMetrics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	tm, err := newTransmitMetrics(reg)
+	if err != nil {
+		t.Fatalf("newTransmitMetrics: %v", err)
+	}
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	mb := &usageModule{usages: []MB_RL7023_11.TransmitUsage{
+		{Used: 30 * time.Second, Remaining: 330 * time.Second},
+		{Used: 340 * time.Second, Remaining: 20 * time.Second},
+		{Used: 350 * time.Second, Remaining: 10 * time.Second},
+	}}
+
+	tests := []struct {
+		used, remaining float64
+		warned          int
+	}{
+		{30, 330, 0},
+		{340, 20, 1},
+		// 残りが少ないままなら警告は繰り返さない
+		{350, 10, 1},
+	}
+	for i, tt := range tests {
+		tm.sample(context.Background(), mb, logger)
+
+		used, _ := metricValue(t, reg, "smartmeter_module_transmit_time_used_seconds", nil)
+		remaining, _ := metricValue(t, reg, "smartmeter_module_transmit_time_remaining_seconds", nil)
+		if used != tt.used || remaining != tt.remaining {
+			t.Errorf("sample %d: used = %v, remaining = %v, want %v, %v", i, used, remaining, tt.used, tt.remaining)
+		}
+		if n := strings.Count(logs.String(), "Approaching the hourly transmit time limit"); n != tt.warned {
+			t.Errorf("sample %d: warned %d times, want %d", i, n, tt.warned)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// SKSTACK IP を実装した Wi-SUN モジュールを、具体的な型に依存せずに扱えるようにします。
+// 実装は MB_RL7023_11 のドライバーだけで、モジュールの種類ごとの出力の違いは Dialect の設定で吸収します。
+package module
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+)
+
+var (
+	ErrUnknownVariant = errors.New("unknown module variant")
+	ErrUnknownVersion = errors.New("cannot detect module variant from the firmware version, specify the module explicitly")
+)
+
+// Wi-SUN モジュールの共通の操作
+type Module interface {
+	// モジュールの種類
+	Variant() Variant
+
+	Initialize(ctx context.Context) error
+	SKVER(ctx context.Context) (*MB_RL7023_11.EVER, error)
+	SKINFO(ctx context.Context) (*MB_RL7023_11.EINFO, error)
+
+	SKSETRBID(ctx context.Context, id string) error
+	SKSETPWD(ctx context.Context, pwd string) error
+	SetChannel(ctx context.Context, channel uint8) error
+	SetPANID(ctx context.Context, panID uint16) error
+	SKLL64(ctx context.Context, addr64 string) (string, error)
+	SKSCAN(ctx context.Context, mode MB_RL7023_11.SKSCANMode, channelMask uint32, duration uint8, reserved MB_RL7023_11.SKSCANReserved) ([]any, error)
+	SKJOIN(ctx context.Context, ipaddr string) error
+	SKREJOIN(ctx context.Context) error
+	SKTERM(ctx context.Context) error
+	Session() MB_RL7023_11.Session
+
+	SKSENDTO(ctx context.Context, handle uint8, ipaddr string, port uint16, sec MB_RL7023_11.SKSENDTOSec, reserved MB_RL7023_11.SKSENDTOReserved, payload []uint8) (*MB_RL7023_11.ERXUDP, error)
+	TransmitLimited() bool
+	TransmitUsage(ctx context.Context) (MB_RL7023_11.TransmitUsage, error)
+
+	SubscribeERXUDP(size int) *MB_RL7023_11.Subscription[*MB_RL7023_11.ERXUDP]
+	SubscribeEVENT(size int, nums ...MB_RL7023_11.EVENTNum) *MB_RL7023_11.Subscription[*MB_RL7023_11.EVENT]
+	SubscribeEPANDESC(size int) *MB_RL7023_11.Subscription[*MB_RL7023_11.EPANDESC]
+	SubscribeModuleReset(size int) *MB_RL7023_11.Subscription[*MB_RL7023_11.ModuleReset]
+	SubscribeSession(size int) *MB_RL7023_11.Subscription[*MB_RL7023_11.SessionStateChange]
+	Dropped() uint64
+}
+
+// モジュールの種類、同じドライバーに設定する書式の違いだけを持ちます。
+type Variant struct {
+	// 設定ファイルで指定する名前
+	Name    string
+	Dialect MB_RL7023_11.Dialect
+}
+
+var (
+	// Tessera RL7023 Stick-D など
+	MBRL7023_11 = Variant{
+		Name:    "mb-rl7023-11",
+		Dialect: MB_RL7023_11.DefaultDialect,
+	}
+	// ROHM BP35A1、SKSCAN、SKSENDTO、ERXUDP のサイドと EPANDESC の Side がない
+	BP35A1 = Variant{
+		Name:    "bp35a1",
+		Dialect: MB_RL7023_11.Dialect{},
+	}
+	// ROHM BP35C0、MB-RL7023-11 の書式に加えて ERXUDP に RSSI がある
+	BP35C0 = Variant{
+		Name: "bp35c0",
+		Dialect: MB_RL7023_11.Dialect{
+			ERXUDPRSSI:   true,
+			ERXUDPSide:   true,
+			SKSENDTOSide: true,
+			SKSCANSide:   true,
+			EPANDESCSide: true,
+		},
+	}
+)
+
+var Variants = []Variant{MBRL7023_11, BP35A1, BP35C0}
+
+// 名前からモジュールの種類を探します。
+func Lookup(name string) (Variant, error) {
+	for _, v := range Variants {
+		if strings.EqualFold(v.Name, name) {
+			return v, nil
+		}
+	}
+	return Variant{}, ErrUnknownVariant
+}
+
+// SKVER のバージョンの前方一致でモジュールを推測します。
+// 1.2 系は MB-RL7023-11 と BP35A1 のどちらにもあり、BP35C0 も見分けられないので含めません。
+var versionPrefixes = []struct {
+	prefix  string
+	variant Variant
+}{
+	// サイドの指定に対応する前のファームウェア
+	{"1.0.", BP35A1},
+	{"1.1.", BP35A1},
+}
+
+// SKVER を実行できるモジュール
+type VersionReader interface {
+	SKVER(ctx context.Context) (*MB_RL7023_11.EVER, error)
+}
+
+// SKVER のバージョンからモジュールの種類を推測します。
+// バージョンだけでは判別できない場合は ErrUnknownVersion を返すので、設定で種類を指定してください。
+func Detect(ctx context.Context, mb VersionReader) (Variant, error) {
+	ver, err := mb.SKVER(ctx)
+	if err != nil {
+		return Variant{}, err
+	}
+
+	for _, p := range versionPrefixes {
+		if strings.HasPrefix(ver.Version, p.prefix) {
+			return p.variant, nil
+		}
+	}
+	return Variant{}, fmt.Errorf("%w: %s", ErrUnknownVersion, ver.Version)
+}
+
+type module struct {
+	*MB_RL7023_11.MB_RL7023_11
+	variant Variant
+}
+
+// mb に v の書式を設定し、Module として返します。
+func New(v Variant, mb *MB_RL7023_11.MB_RL7023_11) Module {
+	mb.SetDialect(v.Dialect)
+	return &module{
+		MB_RL7023_11: mb,
+		variant:      v,
+	}
+}
+
+func (m *module) Variant() Variant {
+	return m.variant
+}
@@ -0,0 +1,186 @@
+package module
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+)
+
+type version string
+
+func (v version) SKVER(ctx context.Context) (*MB_RL7023_11.EVER, error) {
+	return &MB_RL7023_11.EVER{Version: string(v)}, nil
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		version string
+		want    Variant
+		err     error
+	}{
+		{"1.0.3", BP35A1, nil},
+		{"1.1.0", BP35A1, nil},
+		// MB-RL7023-11 と BP35A1 のどちらか分からない
+		{"1.2.10", Variant{}, ErrUnknownVersion},
+		{"", Variant{}, ErrUnknownVersion},
+	}
+	for _, tt := range tests {
+		got, err := Detect(context.Background(), version(tt.version))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("Detect(%q) error = %v, want %v", tt.version, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Detect(%q) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+const (
+	localAddr = "FE80:0000:0000:0000:021D:1290:0000:0001"
+	meterAddr = "FE80:0000:0000:0000:021D:1290:1234:5678"
+)
+
+// コマンドを記録し、決まった応答を返す Transport
+type scriptedTransport struct {
+	mu       sync.Mutex
+	commands []string
+	// SKSENDTO、SKSCAN への応答
+	erxudp   string
+	epandesc []string
+}
+
+func (t *scriptedTransport) Write(b []uint8) (int, error) {
+	return len(b), nil
+}
+
+func (t *scriptedTransport) AddListner(l *func(lines []string) error) {}
+
+func (t *scriptedTransport) Exec(ctx context.Context, command []uint8, stopper func(l []string) bool) ([]string, error) {
+	echo := strings.TrimSuffix(string(command), "\r\n")
+	t.mu.Lock()
+	t.commands = append(t.commands, echo)
+	t.mu.Unlock()
+
+	var res []string
+	switch strings.Fields(echo)[0] {
+	case "ROPT":
+		res = []string{"FAIL ER04"}
+	case "SKTABLE":
+		if strings.HasSuffix(echo, " E") {
+			res = []string{"EPORT", "3610", "716", "0", "0", "0", "0", "", "0", "0", "0", "0", "OK"}
+		} else {
+			res = []string{"EADDR", localAddr, "OK"}
+		}
+	case "SKSENDTO":
+		res = []string{"OK", "EVENT 21 " + meterAddr + " 00", t.erxudp}
+	case "SKSCAN":
+		res = append([]string{"OK", "EVENT 20 " + meterAddr}, t.epandesc...)
+		res = append(res, "EVENT 22 "+localAddr)
+	default:
+		res = []string{"OK"}
+	}
+	return append([]string{echo}, res...), nil
+}
+
+func (t *scriptedTransport) command(prefix string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, c := range t.commands {
+		if strings.HasPrefix(c, prefix) {
+			return c
+		}
+	}
+	return ""
+}
+
+// モジュールの種類ごとに、SKSENDTO と SKSCAN のコマンドの書式と ERXUDP、EPANDESC の解析を確かめる
+func TestVariantFormats(t *testing.T) {
+	epandesc := func(side bool) []string {
+		lines := []string{"EPANDESC", "  Channel:3B", "  Channel Page:09", "  Pan ID:1234", "  Addr:001D129012345678", "  LQI:A0"}
+		if side {
+			lines = append(lines, "  Side:0")
+		}
+		return append(lines, "  PairID:00ABCDEF")
+	}
+
+	tests := []struct {
+		variant  Variant
+		erxudp   string
+		epandesc []string
+		sendto   string
+		scan     string
+		rssi     int8
+	}{
+		{
+			variant:  MBRL7023_11,
+			erxudp:   "ERXUDP " + meterAddr + " " + localAddr + " 0E1A 0E1A 001D129012345678 1 0 0005 48454C4C4F",
+			epandesc: epandesc(true),
+			sendto:   "SKSENDTO 1 " + meterAddr + " 0E1A 2 0 0005 ",
+			scan:     "SKSCAN 2 FFFFFFFF 6 0",
+		},
+		{
+			variant:  BP35A1,
+			erxudp:   "ERXUDP " + meterAddr + " " + localAddr + " 0E1A 0E1A 001D129012345678 1 0005 48454C4C4F",
+			epandesc: epandesc(false),
+			sendto:   "SKSENDTO 1 " + meterAddr + " 0E1A 2 0005 ",
+			scan:     "SKSCAN 2 FFFFFFFF 6",
+		},
+		{
+			variant:  BP35C0,
+			erxudp:   "ERXUDP " + meterAddr + " " + localAddr + " 0E1A 0E1A 001D129012345678 C8 1 0 0005 48454C4C4F",
+			epandesc: epandesc(true),
+			sendto:   "SKSENDTO 1 " + meterAddr + " 0E1A 2 0 0005 ",
+			scan:     "SKSCAN 2 FFFFFFFF 6 0",
+			rssi:     -56,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.variant.Name, func(t *testing.T) {
+			ctx := context.Background()
+			tr := &scriptedTransport{erxudp: tt.erxudp, epandesc: tt.epandesc}
+			mb := MB_RL7023_11.New(MB_RL7023_11.Config{
+				Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+				Transport: tr,
+			})
+			err := mb.Initialize(ctx)
+			if err != nil {
+				t.Fatalf("Initialize: %v", err)
+			}
+			mod := New(tt.variant, mb)
+
+			e, err := mod.SKSENDTO(ctx, 1, meterAddr, 0x0E1A, MB_RL7023_11.SKSENDTOSecModerate, MB_RL7023_11.SKSENDTOReservedValue, []uint8("HELLO"))
+			if err != nil {
+				t.Fatalf("SKSENDTO: %v", err)
+			}
+			if got := tr.command("SKSENDTO"); got != tt.sendto+"HELLO" {
+				t.Errorf("SKSENDTO command = %q, want %q", got, tt.sendto+"HELLO")
+			}
+			if string(e.Data) != "HELLO" || !e.Secured || e.RSSI != tt.rssi || e.Sender != meterAddr {
+				t.Errorf("ERXUDP = %+v, want data HELLO, secured, RSSI %d from %s", e, tt.rssi, meterAddr)
+			}
+
+			res, err := mod.SKSCAN(ctx, MB_RL7023_11.SKSCANModeActiveWithIE, 0xFFFFFFFF, 6, MB_RL7023_11.SKSCANReservedValue)
+			if err != nil {
+				t.Fatalf("SKSCAN: %v", err)
+			}
+			if got := tr.command("SKSCAN"); got != tt.scan {
+				t.Errorf("SKSCAN command = %q, want %q", got, tt.scan)
+			}
+			if len(res) != 1 {
+				t.Fatalf("SKSCAN returned %d PANs, want 1", len(res))
+			}
+			pan := res[0].(*MB_RL7023_11.EPANDESC)
+			if pan.Channel != 0x3B || pan.PanID != 0x1234 || pan.LQI != 0xA0 || pan.PairID != "00ABCDEF" {
+				t.Errorf("EPANDESC = %+v", pan)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
+)
+
+var errPANNotFound = errors.New("PAN not found")
+
+// 接続先の PAN
+type panTarget struct {
+	Channel uint8  `json:"channel"`
+	PanID   uint16 `json:"pan_id"`
+	Addr    string `json:"addr"`
+}
+
+func defaultStateFile() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "akizuki-dg-route-b-exporter", "pan.json")
+}
+
+func loadPAN(path string) (*panTarget, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var p panTarget
+	err = json.Unmarshal(b, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func savePAN(path string, p *panTarget) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0o644)
+}
+
+func scanPANs(ctx context.Context, mb module.Module) ([]*MB_RL7023_11.EPANDESC, error) {
+	res, err := mb.SKSCAN(ctx, MB_RL7023_11.SKSCANModeActiveWithIE, 0xFFFFFFFF, 6, MB_RL7023_11.SKSCANReservedValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var pans []*MB_RL7023_11.EPANDESC
+	for _, p := range res {
+		pan, ok := p.(*MB_RL7023_11.EPANDESC)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", MB_RL7023_11.ErrUnexpectedOutput, p)
+		}
+		pans = append(pans, pan)
+	}
+
+	return pans, nil
+}
+
+// アクティブスキャンで見つかった PAN のうち、LQI が最も良いものを選びます。
+// pairID を指定した場合は Pairing ID が一致する PAN のみを対象にします。
+func discoverPAN(ctx context.Context, mb module.Module, pairID string) (*panTarget, error) {
+	pans, err := scanPANs(ctx, mb)
+	if err != nil {
+		return nil, fmt.Errorf("SKSCAN: %w", err)
+	}
+
+	var best *MB_RL7023_11.EPANDESC
+	for _, p := range pans {
+		if pairID != "" && p.PairID != pairID {
+			continue
+		}
+		if best == nil || p.LQI > best.LQI {
+			best = p
+		}
+	}
+	if best == nil {
+		return nil, errPANNotFound
+	}
+
+	addr, err := mb.SKLL64(ctx, best.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("SKLL64: %w", err)
+	}
+
+	return &panTarget{
+		Channel: best.Channel,
+		PanID:   best.PanID,
+		Addr:    addr,
+	}, nil
+}
@@ -0,0 +1,275 @@
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrReplayDiverged = errors.New("replay diverged from capture")
+
+// 通信の向き
+type Direction string
+
+const (
+	// モジュールへ書き込んだデータ
+	DirectionTX Direction = "tx"
+	// モジュールから読み込んだデータ
+	DirectionRX Direction = "rx"
+)
+
+// キャプチャーファイルの 1 行、JSON Lines 形式で保存します。
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"dir"`
+	Data      []uint8   `json:"data"`
+}
+
+// 秘密の値を伏せた箇所、再生時はどの値とも一致する
+const redacted = "****"
+
+// 読み書きしたデータをすべて w に記録する io.ReadWriteCloser
+type recorder struct {
+	io.ReadWriteCloser
+
+	mu     sync.Mutex
+	w      io.Writer
+	redact func(line string) string
+
+	rxMu sync.Mutex
+	// 行の途中まで受信したデータ、行ごとに伏せてから記録する
+	rx []uint8
+}
+
+// port で読み書きしたデータを時刻と向きを付けて w に記録します。
+// redact が nil でなければ、記録する前に行ごとに適用して秘密の値を伏せます。
+// 伏せなかったデータはルート B の認証情報などをそのまま含むので、記録したファイルの扱いには注意してください。
+func Record(port io.ReadWriteCloser, w io.Writer, redact func(line string) string) io.ReadWriteCloser {
+	return &recorder{
+		ReadWriteCloser: port,
+		w:               w,
+		redact:          redact,
+	}
+}
+
+// data を行ごとに redact に渡し、改行はそのまま残して繋げます。
+func (r *recorder) redactLines(data []uint8) []uint8 {
+	if r.redact == nil {
+		return data
+	}
+
+	var out []uint8
+	for len(data) > 0 {
+		line, rest, found := bytes.Cut(data, []uint8("\r\n"))
+		out = append(out, r.redact(string(line))...)
+		if found {
+			out = append(out, "\r\n"...)
+		}
+		data = rest
+	}
+	return out
+}
+
+func (r *recorder) record(dir Direction, data []uint8) {
+	b, err := json.Marshal(CaptureRecord{
+		Time:      time.Now(),
+		Direction: dir,
+		Data:      data,
+	})
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 記録に失敗しても通信は止めない
+	r.w.Write(append(b, '\n'))
+}
+
+func (r *recorder) SetBaudRate(baudRate int) error {
+	return setBaudRate(r.ReadWriteCloser, baudRate)
+}
+
+func (r *recorder) onReconnect(f func()) {
+	if p, ok := r.ReadWriteCloser.(reconnector); ok {
+		p.onReconnect(f)
+	}
+}
+
+func (r *recorder) Read(p []uint8) (int, error) {
+	n, err := r.ReadWriteCloser.Read(p)
+	if n > 0 {
+		if r.redact == nil {
+			r.record(DirectionRX, p[:n])
+			return n, err
+		}
+
+		// エコーバックが読み込みの境界で分かれても伏せられるよう、行が揃ってから記録する
+		r.rxMu.Lock()
+		defer r.rxMu.Unlock()
+
+		r.rx = append(r.rx, p[:n]...)
+		if i := bytes.LastIndex(r.rx, []uint8("\r\n")); i != -1 {
+			r.record(DirectionRX, r.redactLines(r.rx[:i+2]))
+			r.rx = slices.Clone(r.rx[i+2:])
+		}
+	}
+	return n, err
+}
+
+func (r *recorder) Write(p []uint8) (int, error) {
+	n, err := r.ReadWriteCloser.Write(p)
+	if n > 0 {
+		r.record(DirectionTX, r.redactLines(p[:n]))
+	}
+	return n, err
+}
+
+func (r *recorder) Close() error {
+	r.rxMu.Lock()
+	defer r.rxMu.Unlock()
+
+	if len(r.rx) > 0 {
+		r.record(DirectionRX, r.redactLines(r.rx))
+		r.rx = nil
+	}
+	return r.ReadWriteCloser.Close()
+}
+
+// キャプチャーファイルを再生する io.ReadWriteCloser
+// 記録された tx と同じデータが書き込まれるたびに、その後に続く rx を読み込めるようにします。
+// 最後まで再生すると Read は io.EOF を返します。
+type replayPort struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	records []CaptureRecord
+	pos     int
+	buff    []uint8
+	closed  bool
+}
+
+func openReplay(path string) (io.ReadWriteCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []CaptureRecord
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]uint8, 0, 64*1024), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var r CaptureRecord
+		err := json.Unmarshal(s.Bytes(), &r)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	r := &replayPort{records: records}
+	r.cond = sync.NewCond(&r.mu)
+	// 最初の書き込みより前に受信していたデータ
+	r.release()
+
+	return r, nil
+}
+
+// 次の tx までの rx を読み込めるようにします。r.mu を保持して呼び出します。
+func (r *replayPort) release() {
+	for r.pos < len(r.records) && r.records[r.pos].Direction == DirectionRX {
+		r.buff = append(r.buff, r.records[r.pos].Data...)
+		r.pos++
+	}
+	r.cond.Broadcast()
+}
+
+func (r *replayPort) Read(p []uint8) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for len(r.buff) == 0 && !r.closed && r.pos < len(r.records) {
+		r.cond.Wait()
+	}
+
+	if len(r.buff) > 0 {
+		n := copy(p, r.buff)
+		r.buff = r.buff[n:]
+		return n, nil
+	}
+
+	return 0, io.EOF
+}
+
+func (r *replayPort) Write(p []uint8) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if r.pos >= len(r.records) {
+		return 0, fmt.Errorf("%w: unexpected write %q after end of capture", ErrReplayDiverged, p)
+	}
+
+	expected := r.records[r.pos].Data
+	if !replayMatch(expected, p) {
+		return 0, fmt.Errorf("%w: expected %q, got %q", ErrReplayDiverged, expected, p)
+	}
+
+	r.pos++
+	r.release()
+
+	return len(p), nil
+}
+
+// 書き込まれたデータが記録と一致するかを返します。伏せた値はどの値とも一致します。
+func replayMatch(expected, p []uint8) bool {
+	if bytes.Equal(expected, p) {
+		return true
+	}
+	if !bytes.Contains(expected, []uint8(redacted)) {
+		return false
+	}
+
+	e, a := strings.Fields(string(expected)), strings.Fields(string(p))
+	if len(e) != len(a) {
+		return false
+	}
+	for i := range e {
+		if e[i] != redacted && e[i] != a[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 再生では何もしません。
+func (r *replayPort) SetBaudRate(baudRate int) error {
+	return nil
+}
+
+func (r *replayPort) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	r.cond.Broadcast()
+
+	return nil
+}
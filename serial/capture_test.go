@@ -0,0 +1,100 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// SKSETPWD の引数を伏せる
+func redactPassword(line string) string {
+	if !strings.HasPrefix(line, "SKSETPWD ") {
+		return line
+	}
+	fields := strings.Fields(line)
+	fields[2] = redacted
+	return strings.Join(fields, " ")
+}
+
+// 書き込みと、読み込みの境界で分かれたエコーバックの両方で秘密の値を伏せ、伏せたキャプチャーも再生できる
+func TestRecordRedacts(t *testing.T) {
+	const command = "SKSETPWD C secretsecret\r\n"
+
+	port := newFakePort(func(command string) []string { return []string{"OK"} })
+	var buf bytes.Buffer
+	r := Record(port, &buf, redactPassword)
+
+	_, err := r.Write([]uint8(command))
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// fakePort は 7 バイトずつ返すので、エコーバックは何回かに分かれて届く
+	var rx []uint8
+	p := make([]uint8, 64)
+	for !bytes.HasSuffix(rx, []uint8("OK\r\n")) {
+		n, err := r.Read(p)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		rx = append(rx, p[:n]...)
+	}
+	if string(rx) != command+"OK\r\n" {
+		t.Errorf("Read = %q, want the port data unchanged", rx)
+	}
+	r.Close()
+
+	// Data は base64 で保存されるので、戻してから確かめる
+	var recorded []uint8
+	for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []uint8("\n")) {
+		var rec CaptureRecord
+		err := json.Unmarshal(line, &rec)
+		if err != nil {
+			t.Fatalf("Unmarshal(%q): %v", line, err)
+		}
+		recorded = append(recorded, rec.Data...)
+	}
+	if want := "SKSETPWD C ****\r\nSKSETPWD C ****\r\nOK\r\n"; string(recorded) != want {
+		t.Fatalf("recorded %q, want %q", recorded, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	err = os.WriteFile(path, buf.Bytes(), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := openReplay(path)
+	if err != nil {
+		t.Fatalf("openReplay: %v", err)
+	}
+	defer replay.Close()
+
+	_, err = replay.Write([]uint8(command))
+	if err != nil {
+		t.Fatalf("replay Write: %v", err)
+	}
+	got, err := io.ReadAll(replay)
+	if err != nil {
+		t.Fatalf("replay Read: %v", err)
+	}
+	if want := "SKSETPWD C ****\r\nOK\r\n"; string(got) != want {
+		t.Errorf("replay Read = %q, want %q", got, want)
+	}
+
+	_, err = openReplayWrite(path, "SKSETPWD B secretsecre\r\n")
+	if err == nil {
+		t.Error("replay accepted a command with a different length argument")
+	}
+}
+
+func openReplayWrite(path, command string) (int, error) {
+	replay, err := openReplay(path)
+	if err != nil {
+		return 0, err
+	}
+	defer replay.Close()
+	return replay.Write([]uint8(command))
+}
@@ -0,0 +1,175 @@
+package serial
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 複数行からなるイベントの終わり方
+type groupEnd int
+
+const (
+	// OK の行の手前まで
+	groupEndOK groupEnd = iota
+	// インデントされた行が続く間、PairID の行で終わる
+	groupEndIndent
+	// 続く 1 行まで
+	groupEndNextLine
+)
+
+var multiLineEvents = map[string]groupEnd{
+	"EADDR":     groupEndOK,
+	"ENEIGHBOR": groupEndOK,
+	"EPORT":     groupEndOK,
+	"EHANDLE":   groupEndOK,
+	"EPANDESC":  groupEndIndent,
+	"EEDSCAN":   groupEndNextLine,
+}
+
+// 受信したデータを CRLF で区切った行に分けます。
+// 複数行からなるイベントは、読み込みの境界に関係なく 1 つのフレームにまとめます。
+type framer struct {
+	buff  string
+	group []string
+	end   groupEnd
+	// バイナリ表示 (WOPT 00) のデータ部を含むイベントごとの、データ長までのフィールドの数
+	// 含まれるイベントは CRLF ではなくデータ長で区切る
+	binary map[string]int
+}
+
+// data を追加し、完成したフレームを返します。
+func (f *framer) Write(data []uint8) [][]string {
+	f.buff += string(data)
+
+	var frames [][]string
+	for {
+		line, ok := f.next()
+		if !ok {
+			break
+		}
+
+		frames = append(frames, f.line(line)...)
+	}
+
+	return frames
+}
+
+// バッファーから 1 行取り出します。
+func (f *framer) next() (string, bool) {
+	if f.binary != nil {
+		n, ok := binaryLine(f.buff, f.binary)
+		if ok {
+			if n == -1 {
+				return "", false
+			}
+			line := f.buff[:n]
+			f.buff = strings.TrimPrefix(f.buff[n:], "\r\n")
+			return line, true
+		}
+	}
+
+	i := strings.Index(f.buff, "\r\n")
+	if i == -1 {
+		return "", false
+	}
+	line := f.buff[:i]
+	f.buff = f.buff[i+2:]
+	return line, true
+}
+
+// バイナリ表示のデータ部を含む行であれば、データ長から求めた CRLF を除く行の長さを返します。
+// 行を最後まで受信していない場合は -1 を返します。該当しない行の場合 ok は false です。
+func binaryLine(buff string, binaryEvents map[string]int) (n int, ok bool) {
+	id, _, found := strings.Cut(buff, " ")
+	if !found {
+		return 0, false
+	}
+	fields, ok := binaryEvents[id]
+	if !ok {
+		return 0, false
+	}
+
+	pos := len(id) + 1
+	field := ""
+	for range fields {
+		i := strings.IndexByte(buff[pos:], ' ')
+		if i == -1 {
+			// ヘッダーの途中で行が終わっている
+			if strings.Contains(buff[pos:], "\r\n") {
+				return 0, false
+			}
+			return -1, true
+		}
+		field = buff[pos : pos+i]
+		if strings.Contains(field, "\r\n") {
+			return 0, false
+		}
+		pos += i + 1
+	}
+
+	length, err := strconv.ParseUint(field, 16, 16)
+	if err != nil {
+		return 0, false
+	}
+	n = pos + int(length)
+	if len(buff) < n+len("\r\n") {
+		return -1, true
+	}
+	return n, true
+}
+
+func (f *framer) line(line string) [][]string {
+	var frames [][]string
+
+	if f.group != nil {
+		switch f.end {
+		case groupEndOK:
+			if line != "OK" && !strings.HasPrefix(line, "FAIL") {
+				f.group = append(f.group, line)
+				return nil
+			}
+		case groupEndIndent:
+			if strings.HasPrefix(line, " ") {
+				f.group = append(f.group, line)
+				if strings.HasPrefix(strings.TrimSpace(line), "PairID:") {
+					return [][]string{f.flush()}
+				}
+				return nil
+			}
+		case groupEndNextLine:
+			f.group = append(f.group, line)
+			return [][]string{f.flush()}
+		}
+
+		// 終わりの行はイベントに含めず、続けて通常の行として扱う
+		frames = append(frames, f.flush())
+	}
+
+	id, _, _ := strings.Cut(line, " ")
+	if end, ok := multiLineEvents[id]; ok {
+		f.group = []string{line}
+		f.end = end
+		return frames
+	}
+
+	return append(frames, []string{line})
+}
+
+func (f *framer) flush() []string {
+	group := f.group
+	f.group = nil
+	return group
+}
+
+// 受信途中のイベントを含め、残っているデータをすべて返します。
+func (f *framer) Flush() [][]string {
+	var frames [][]string
+	if f.group != nil {
+		frames = append(frames, f.flush())
+	}
+	if f.buff != "" {
+		frames = append(frames, []string{f.buff})
+		f.buff = ""
+	}
+	return frames
+}
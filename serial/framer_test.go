@@ -0,0 +1,72 @@
+package serial
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFramer(t *testing.T) {
+	long := "ERXUDP " + strings.Repeat("0123456789ABCDEF", 64)
+
+	tests := []struct {
+		name   string
+		chunks []string
+		want   [][]string
+		// Flush で取り出す、行の途中まで受信したデータ
+		rest [][]string
+	}{
+		{
+			name:   "CRLF split across reads",
+			chunks: []string{"OK\r", "\nFAIL ER04\r", "\n"},
+			want:   [][]string{{"OK"}, {"FAIL ER04"}},
+		},
+		{
+			name:   "line split across reads",
+			chunks: []string{"EVENT 2", "1 FE80:0000:0000:0000:021D:1290:1234:5678 00\r\n"},
+			want:   [][]string{{"EVENT 21 FE80:0000:0000:0000:021D:1290:1234:5678 00"}},
+		},
+		{
+			// CR だけでは行を区切らない
+			name:   "bare CR",
+			chunks: []string{"SKSENDTO\r", "OK\r\n"},
+			want:   [][]string{{"SKSENDTO\rOK"}},
+		},
+		{
+			name:   "bare CR at the end",
+			chunks: []string{"OK\r"},
+			rest:   [][]string{{"OK\r"}},
+		},
+		{
+			// 一度の読み込みより長い行も 1 行にまとめる
+			name:   "overlong line",
+			chunks: []string{long[:255], long[255:510], long[510:] + "\r\n"},
+			want:   [][]string{{long}},
+		},
+		{
+			name:   "overlong line without CRLF",
+			chunks: []string{long[:255], long[255:]},
+			rest:   [][]string{{long}},
+		},
+		{
+			name:   "multi-line event split across reads",
+			chunks: []string{"EADDR\r\nFE80:0000:0000:0000", ":021D:1290:0000:0001\r\nO", "K\r\n"},
+			want:   [][]string{{"EADDR", "FE80:0000:0000:0000:021D:1290:0000:0001"}, {"OK"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &framer{}
+			var got [][]string
+			for _, c := range tt.chunks {
+				got = append(got, f.Write([]uint8(c))...)
+			}
+			if !slices.EqualFunc(got, tt.want, slices.Equal) {
+				t.Errorf("Write = %q, want %q", got, tt.want)
+			}
+			if rest := f.Flush(); !slices.EqualFunc(rest, tt.rest, slices.Equal) {
+				t.Errorf("Flush = %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
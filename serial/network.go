@@ -0,0 +1,227 @@
+package serial
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+)
+
+var ErrDisconnected = errors.New("disconnected")
+
+const (
+	dialTimeout       = 10 * time.Second
+	initialRetryDelay = 1 * time.Second
+	maxRetryDelay     = 30 * time.Second
+)
+
+// ネットワーク越しのシリアルポート (ser2net など) に接続します。
+// 切断された場合は Read の中で再接続するので、Streaming はエラーで終了しません。
+type netPort struct {
+	dial    func(baudRate int) (net.Conn, error)
+	rfc2217 bool
+
+	mu       sync.Mutex
+	baudRate int
+	conn     net.Conn
+	retryAt  time.Time
+	delay    time.Duration
+	closed   bool
+	done     chan struct{}
+	// 再接続したときに呼ぶ
+	reconnected func()
+}
+
+// tcp://host:port と rfc2217://host:port の URL を開きます。
+func openURL(u *url.URL, baudRate int) (io.ReadWriteCloser, error) {
+	if u.Host == "" {
+		return nil, errors.New("missing host in " + u.String())
+	}
+
+	var dial func(baudRate int) (net.Conn, error)
+	switch u.Scheme {
+	case "tcp":
+		dial = func(int) (net.Conn, error) {
+			return net.DialTimeout("tcp", u.Host, dialTimeout)
+		}
+	case "rfc2217":
+		dial = func(baudRate int) (net.Conn, error) {
+			return dialRFC2217(u.Host, baudRate)
+		}
+	default:
+		return nil, errors.New("unsupported scheme: " + u.Scheme)
+	}
+
+	conn, err := dial(baudRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &netPort{
+		dial:     dial,
+		rfc2217:  u.Scheme == "rfc2217",
+		baudRate: baudRate,
+		conn:     conn,
+		delay:    initialRetryDelay,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// 接続済みの conn を返します。切断中なら待ち時間が過ぎていれば再接続します。
+func (p *netPort) connect(wait bool) (net.Conn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, io.EOF
+	}
+	if p.conn != nil {
+		conn := p.conn
+		p.mu.Unlock()
+		return conn, nil
+	}
+	retryAt := p.retryAt
+	baudRate := p.baudRate
+	p.mu.Unlock()
+
+	if d := time.Until(retryAt); d > 0 {
+		if !wait {
+			return nil, ErrDisconnected
+		}
+		select {
+		case <-p.done:
+			return nil, io.EOF
+		case <-time.After(d):
+		}
+	}
+
+	conn, err := p.dial(baudRate)
+
+	p.mu.Lock()
+	if err != nil {
+		p.retryAt = time.Now().Add(p.delay)
+		p.delay = min(p.delay*2, maxRetryDelay)
+		p.mu.Unlock()
+		return nil, err
+	}
+	if p.closed {
+		p.mu.Unlock()
+		conn.Close()
+		return nil, io.EOF
+	}
+	if p.conn != nil {
+		// 別の goroutine が先に再接続していた
+		current := p.conn
+		p.mu.Unlock()
+		conn.Close()
+		return current, nil
+	}
+
+	p.conn = conn
+	p.delay = initialRetryDelay
+	reconnected := p.reconnected
+	p.mu.Unlock()
+
+	// 接続し直した先のモジュールは状態を失っているかもしれない
+	if reconnected != nil {
+		reconnected()
+	}
+	return conn, nil
+}
+
+func (p *netPort) onReconnect(f func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.reconnected = f
+}
+
+// conn を切断済みとして扱い、次の Read で再接続させます。
+func (p *netPort) drop(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conn != conn {
+		return
+	}
+	conn.Close()
+	p.conn = nil
+	p.retryAt = time.Now().Add(p.delay)
+}
+
+func (p *netPort) Read(b []uint8) (int, error) {
+	conn, err := p.connect(true)
+	if err == io.EOF {
+		return 0, io.EOF
+	}
+	if err != nil {
+		// 再接続に失敗しても Streaming は止めず、次の Read で再試行する
+		return 0, nil
+	}
+
+	n, err := conn.Read(b)
+	if err != nil {
+		p.drop(conn)
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	return n, nil
+}
+
+func (p *netPort) Write(b []uint8) (int, error) {
+	conn, err := p.connect(false)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := conn.Write(b)
+	if err != nil {
+		p.drop(conn)
+		return n, err
+	}
+
+	return n, nil
+}
+
+// RFC 2217 の場合はサーバー側のボーレートを変更します。再接続した後も同じボーレートを使います。
+func (p *netPort) SetBaudRate(baudRate int) error {
+	if !p.rfc2217 {
+		return MB_RL7023_11.ErrBaudRateUnsupported
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.baudRate = baudRate
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.(*telnetConn).setBaudRate(baudRate)
+}
+
+func (p *netPort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
@@ -0,0 +1,214 @@
+// https://datatracker.ietf.org/doc/html/rfc2217
+package serial
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// Telnet コマンド
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+)
+
+// Telnet オプション
+const (
+	telnetOptionBinary          = 0
+	telnetOptionSuppressGoAhead = 3
+	telnetOptionComPort         = 44
+)
+
+// COM-PORT-OPTION のサブコマンド (クライアントからサーバー)
+const (
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+)
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// RFC 2217 に対応した Telnet サーバーに接続し、8N1 と baudRate を設定します。
+func dialRFC2217(addr string, baudRate int) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &telnetConn{Conn: conn}
+
+	b := []uint8{
+		telnetIAC, telnetWILL, telnetOptionBinary,
+		telnetIAC, telnetDO, telnetOptionBinary,
+		telnetIAC, telnetWILL, telnetOptionSuppressGoAhead,
+		telnetIAC, telnetDO, telnetOptionSuppressGoAhead,
+		telnetIAC, telnetWILL, telnetOptionComPort,
+	}
+	b = append(b, baudRateCommand(baudRate)...)
+	b = append(b, comPortCommand(comPortSetDataSize, 8)...)
+	// 1: NONE
+	b = append(b, comPortCommand(comPortSetParity, 1)...)
+	// 1: 1 stop bit
+	b = append(b, comPortCommand(comPortSetStopSize, 1)...)
+
+	conn.SetWriteDeadline(time.Now().Add(dialTimeout))
+	_, err = t.writeRaw(b)
+	conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func baudRateCommand(baudRate int) []uint8 {
+	return comPortCommand(comPortSetBaudRate, binary.BigEndian.AppendUint32(nil, uint32(baudRate))...)
+}
+
+func comPortCommand(cmd uint8, value ...uint8) []uint8 {
+	b := []uint8{telnetIAC, telnetSB, telnetOptionComPort, cmd}
+	b = append(b, escapeIAC(value)...)
+	return append(b, telnetIAC, telnetSE)
+}
+
+func escapeIAC(p []uint8) []uint8 {
+	b := make([]uint8, 0, len(p))
+	for _, c := range p {
+		if c == telnetIAC {
+			b = append(b, telnetIAC)
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// Telnet のコマンドを取り除き、データ中の 0xFF をエスケープする net.Conn
+type telnetConn struct {
+	net.Conn
+
+	wmu    sync.Mutex
+	state  int
+	verb   uint8
+	replys []uint8
+}
+
+func (t *telnetConn) writeRaw(b []uint8) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+
+	return t.Conn.Write(b)
+}
+
+func (t *telnetConn) setBaudRate(baudRate int) error {
+	_, err := t.writeRaw(baudRateCommand(baudRate))
+	return err
+}
+
+func (t *telnetConn) Write(p []uint8) (int, error) {
+	_, err := t.writeRaw(escapeIAC(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *telnetConn) Read(p []uint8) (int, error) {
+	for {
+		n, err := t.Conn.Read(p)
+		n = t.filter(p[:n])
+
+		if len(t.replys) > 0 {
+			_, werr := t.writeRaw(t.replys)
+			t.replys = t.replys[:0]
+			if werr != nil && err == nil {
+				err = werr
+			}
+		}
+
+		// コマンドだけを受信した場合は読み直す
+		if n == 0 && err == nil {
+			continue
+		}
+		return n, err
+	}
+}
+
+// p から Telnet コマンドを取り除き、残ったデータの長さを返します。
+func (t *telnetConn) filter(p []uint8) int {
+	n := 0
+	for _, c := range p {
+		switch t.state {
+		case telnetStateData:
+			if c == telnetIAC {
+				t.state = telnetStateIAC
+				continue
+			}
+			p[n] = c
+			n++
+
+		case telnetStateIAC:
+			switch c {
+			case telnetIAC:
+				p[n] = c
+				n++
+				t.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.verb = c
+				t.state = telnetStateOption
+			case telnetSB:
+				t.state = telnetStateSB
+			default:
+				t.state = telnetStateData
+			}
+
+		case telnetStateOption:
+			t.negotiate(t.verb, c)
+			t.state = telnetStateData
+
+		case telnetStateSB:
+			// サーバーからの通知 (NOTIFY-LINESTATE など) は使わないので読み捨てる
+			if c == telnetIAC {
+				t.state = telnetStateSBIAC
+			}
+
+		case telnetStateSBIAC:
+			if c == telnetSE {
+				t.state = telnetStateData
+			} else {
+				t.state = telnetStateSB
+			}
+		}
+	}
+	return n
+}
+
+func (t *telnetConn) negotiate(verb uint8, option uint8) {
+	supported := option == telnetOptionBinary || option == telnetOptionSuppressGoAhead || option == telnetOptionComPort
+
+	switch verb {
+	case telnetDO:
+		// 要求済みのオプションへの応答にはもう一度返さない
+		if !supported {
+			t.replys = append(t.replys, telnetIAC, telnetWONT, option)
+		}
+	case telnetWILL:
+		if !supported {
+			t.replys = append(t.replys, telnetIAC, telnetDONT, option)
+		}
+	}
+}
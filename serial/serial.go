@@ -3,10 +3,18 @@ package serial
 import (
 	"context"
 	"errors"
+	"io"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/albenik/go-serial/v2"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
 )
 
 var (
@@ -15,151 +23,440 @@ var (
 	ErrNotStreaming     = errors.New("not streaming")
 )
 
+// Read が 0 バイトで戻る (ブロックしない) ポートの場合の再試行間隔
+const pollInterval = 2 * time.Millisecond
+
 type Serial struct {
-	listners  []*func(lines []string) error
-	port      *serial.Port
-	streaming bool
+	mu             sync.Mutex
+	listners       []*func(lines []string) error
+	reopenListners []func()
+	// コマンドを 1 つずつ実行するためのセマフォ
+	cmd    chan struct{}
+	logger *slog.Logger
+	reopen func() (io.ReadWriteCloser, error)
+	port   io.ReadWriteCloser
+	// SetBaudRate で変更したボーレート、開き直したときにも適用する
+	baudRate int
+	// バイナリ表示 (WOPT 00) のデータ部を含むイベントごとの、データ長までのフィールドの数
+	binaryPayload atomic.Pointer[map[string]int]
+	closed        atomic.Bool
+	streaming     atomic.Bool
+	// ポートが内部で再接続したので、受信途中のフレームを捨てる
+	reconnected atomic.Bool
 }
 
 type Config struct {
-	PortName string
-	BaudRate int
+	Logger *slog.Logger
+	// Wi-SUN モジュールとの通信路、シリアルポート以外にパイプやシミュレーターなども使える
+	Port io.ReadWriteCloser
+	// 指定した場合、読み込みに失敗したときにポートを開き直す
+	Reopen func() (io.ReadWriteCloser, error)
 }
 
-func New(c Config) (*Serial, error) {
-	port, err := serial.Open(c.PortName, serial.WithBaudrate(c.BaudRate))
-	if err != nil {
-		return nil, err
+func New(c Config) *Serial {
+	logger := c.Logger
+	if logger == nil {
+		logger = slog.Default()
 	}
 
-	return &Serial{
+	s := &Serial{
 		listners: []*func(lines []string) error{},
-		port:     port,
-	}, nil
+		cmd:      make(chan struct{}, 1),
+		logger:   logger,
+		reopen:   c.Reopen,
+		port:     c.Port,
+	}
+	s.watchReconnect(c.Port)
+
+	return s
+}
+
+// 読み書きの中で再接続するポート (ser2net など)、再接続するたびに f を呼びます。
+type reconnector interface {
+	onReconnect(f func())
+}
+
+func (s *Serial) watchReconnect(port io.ReadWriteCloser) {
+	r, ok := port.(reconnector)
+	if !ok {
+		return
+	}
+	r.onReconnect(func() {
+		s.reconnected.Store(true)
+		s.logger.Info("Serial port reconnected")
+		s.notifyReopen()
+	})
+}
+
+// ポートを開き直したことを AddReopenListner で登録した関数に知らせます。
+func (s *Serial) notifyReopen() {
+	s.mu.Lock()
+	listners := s.reopenListners
+	s.mu.Unlock()
+
+	for _, l := range listners {
+		l()
+	}
+}
+
+// シリアルポートを開きます。
+// name には /dev/ttyUSB0 のようなデバイスの他に tcp://host:port (ser2net など) と
+// rfc2217://host:port を指定できます。tcp:// の場合、ボーレートはサーバー側の設定に従います。
+// replay://path を指定すると、Record で保存したキャプチャーファイルを再生します。
+func Open(name string, baudRate int) (io.ReadWriteCloser, error) {
+	if u, err := url.Parse(name); err == nil {
+		switch u.Scheme {
+		case "tcp", "rfc2217":
+			return openURL(u, baudRate)
+		case "replay":
+			return openReplay(u.Host + u.Path)
+		}
+	}
+
+	return serial.Open(name, serial.WithBaudrate(baudRate))
+}
+
+// Open と同じ name を開く関数を返します。Config.Reopen に渡します。
+// USB を挿し直すとデバイス名が変わることがあるため、/dev/serial/by-id にリンクがあればそちらを開きます。
+func Opener(name string, baudRate int) func() (io.ReadWriteCloser, error) {
+	name = stableDevicePath(name)
+	return func() (io.ReadWriteCloser, error) {
+		return Open(name, baudRate)
+	}
+}
+
+func stableDevicePath(name string) string {
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return name
+	}
+
+	links, _ := filepath.Glob("/dev/serial/by-id/*")
+	for _, link := range links {
+		t, err := filepath.EvalSymlinks(link)
+		if err == nil && t == target {
+			return link
+		}
+	}
+
+	return name
 }
 
 func (s *Serial) AddListner(l *func(lines []string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.listners = append(s.listners, l)
 }
 
 func (s *Serial) RemoveListner(l *func(lines []string) error) {
-	for i, listner := range s.listners {
-		if listner == l {
-			s.listners = append(s.listners[:i], s.listners[i+1:]...)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Streaming が配信中のスライスを書き換えないよう、作り直す
+	s.listners = slices.DeleteFunc(slices.Clone(s.listners), func(listner *func(lines []string) error) bool {
+		return listner == l
+	})
+}
+
+// ポートを開き直し、モジュールの状態が失われたときに呼ぶ関数を登録します。
+func (s *Serial) AddReopenListner(l func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reopenListners = append(s.reopenListners, l)
+}
+
+func (s *Serial) getPort() io.ReadWriteCloser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.port
+}
+
+func (s *Serial) snapshot() []*func(lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.listners
+}
+
+type chunk struct {
+	data []uint8
+	err  error
+}
+
+// port から読み続けて ch に送ります。Read がブロックするかどうかは問いません。
+func read(port io.Reader, done chan struct{}, ch chan<- chunk) {
+	for {
+		buff := make([]uint8, 255)
+		n, err := port.Read(buff)
+		if n == 0 && err == nil {
+			select {
+			case <-done:
+				return
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+
+		select {
+		case <-done:
+			return
+		case ch <- chunk{data: buff[:n], err: err}:
+		}
+		if err != nil {
 			return
 		}
 	}
 }
 
+func (s *Serial) emit(frames [][]string) error {
+	for _, frame := range frames {
+		for _, listner := range s.snapshot() {
+			err := (*listner)(frame)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// port から読み込んだフレームをリスナーに渡します。io.EOF 以外で読み込みに失敗した場合は lost が true になります。
+func (s *Serial) receive(ctx context.Context, port io.Reader, f *framer) (lost bool, err error) {
+	done := make(chan struct{})
+	defer close(done)
+	ch := make(chan chunk)
+	go read(port, done, ch)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+
+		case c := <-ch:
+			if s.reconnected.Swap(false) {
+				// 切断前に受信した行の途中は、再接続後のデータと繋がらない
+				*f = framer{}
+			}
+			f.binary = nil
+			if b := s.binaryPayload.Load(); b != nil {
+				f.binary = *b
+			}
+			err := s.emit(f.Write(c.data))
+			if err != nil {
+				return false, err
+			}
+
+			if c.err != nil {
+				// 切断された場合も受信済みのデータはリスナーに渡す
+				err := s.emit(f.Flush())
+				if err != nil {
+					return false, err
+				}
+				// io.EOF はデータの終わり (パイプやキャプチャーの再生) なので開き直さない
+				return !errors.Is(c.err, io.EOF), c.err
+			}
+		}
+	}
+}
+
+// 開き直せるまで再試行します。
+func (s *Serial) reopenPort(ctx context.Context) (io.ReadWriteCloser, error) {
+	delay := initialRetryDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if s.closed.Load() {
+			return nil, io.EOF
+		}
+
+		port, err := s.reopen()
+		if err == nil {
+			return port, nil
+		}
+		s.logger.Warn("Failed to reopen serial port", "err", err, "retry", delay)
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
 func (s *Serial) Streaming(ctx context.Context, ready chan struct{}, errCh chan error) {
-	if s.streaming {
+	if !s.streaming.CompareAndSwap(false, true) {
 		errCh <- ErrAlreadyStreaming
 		return
 	}
 
-	defer func() {
-		s.streaming = false
-	}()
+	defer s.streaming.Store(false)
 
-	s.streaming = true
 	ready <- struct{}{}
 
-	buff := make([]uint8, 255)
-	data := ""
-	olddata := ""
-	emptyCount := 0
+	f := &framer{}
+	port := s.getPort()
 	for {
-		select {
-		case <-ctx.Done():
-			errCh <- ctx.Err()
+		lost, err := s.receive(ctx, port, f)
+		if !lost || s.reopen == nil || s.closed.Load() {
+			errCh <- err
 			return
-		default:
 		}
 
-		n, err := s.port.Read(buff)
+		s.logger.Warn("Serial port lost, reopening", "err", err)
+		port.Close()
+
+		port, err = s.reopenPort(ctx)
 		if err != nil {
 			errCh <- err
 			return
 		}
 
-		if n != 0 {
-			emptyCount = 0
+		s.watchReconnect(port)
+		// 以前のポートで受信途中だったフレームを引き継がない
+		f = &framer{}
+		s.reconnected.Store(false)
 
-			data += string(buff[:n])
+		s.mu.Lock()
+		s.port = port
+		baudRate := s.baudRate
+		s.mu.Unlock()
 
-			continue
+		if baudRate != 0 {
+			err := setBaudRate(port, baudRate)
+			if err != nil {
+				s.logger.Warn("Failed to restore baud rate", "err", err, "baud_rate", baudRate)
+			}
 		}
 
-		emptyCount++
+		s.logger.Info("Serial port reopened")
+		s.notifyReopen()
+	}
+}
 
-		if emptyCount < 5 || data == olddata || strings.HasSuffix(data, "\r") {
-			time.Sleep(2 * time.Duration(emptyCount) * time.Millisecond)
-			continue
-		}
+// ERXUDP などのデータ部をバイナリ表示として、データ長で区切るよう設定します。
+// fields はイベントごとのデータ長までのフィールドの数で、nil の場合は CRLF で区切ります。
+func (s *Serial) SetBinaryPayload(fields map[string]int) {
+	if fields == nil {
+		s.binaryPayload.Store(nil)
+		return
+	}
+	s.binaryPayload.Store(&fields)
+}
 
-		for _, listner := range s.listners {
-			err := (*listner)(strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n"))
-			if err != nil {
-				errCh <- err
-				return
-			}
-		}
+// ホスト側のボーレートを変更します。実行中のコマンドが終わるのを待ってから変更します。
+func (s *Serial) SetBaudRate(baudRate int) error {
+	s.cmd <- struct{}{}
+	defer func() { <-s.cmd }()
 
-		olddata = data
-		data = ""
-		emptyCount = 0
+	err := setBaudRate(s.getPort(), baudRate)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.baudRate = baudRate
+	return nil
+}
+
+func setBaudRate(port io.ReadWriteCloser, baudRate int) error {
+	switch p := port.(type) {
+	case interface {
+		Reconfigure(opts ...serial.Option) error
+	}:
+		return p.Reconfigure(serial.WithBaudrate(baudRate))
+	case interface{ SetBaudRate(baudRate int) error }:
+		return p.SetBaudRate(baudRate)
+	default:
+		return MB_RL7023_11.ErrBaudRateUnsupported
 	}
 }
 
 func (s *Serial) Close() error {
-	return s.port.Close()
+	s.closed.Store(true)
+	return s.getPort().Close()
 }
 
+// 実行中のコマンドが終わるのを待ってから p を書き込みます。
 func (s *Serial) Write(p []uint8) (n int, err error) {
-	return s.port.Write(p)
+	s.cmd <- struct{}{}
+	defer func() { <-s.cmd }()
+
+	return s.getPort().Write(p)
 }
 
+// OK または FAIL の行を受信するまで待つ、Exec の既定の stopper です。
+func ResultStopper(l []string) bool {
+	return slices.ContainsFunc(l, func(line string) bool {
+		return line == "OK" || strings.HasPrefix(line, "OK ") || strings.HasPrefix(line, "FAIL")
+	})
+}
+
+// command を送り、stopper が true を返すまでに受信した行を返します。stopper が nil の場合は ResultStopper を使います。
+// 複数の goroutine から呼び出された場合は、先に実行中のコマンドが終わるまで待ちます。
+// ctx が終了した場合は、それまでに受信した行をエラーと一緒に返します。
 func (s *Serial) Exec(ctx context.Context, command []uint8, stopper func(l []string) bool) ([]string, error) {
-	if !s.streaming {
+	if !s.streaming.Load() {
 		return []string{}, ErrNotStreaming
 	}
 
+	select {
+	case <-ctx.Done():
+		return []string{}, ctx.Err()
+	case s.cmd <- struct{}{}:
+	}
+	defer func() { <-s.cmd }()
+
+	if stopper == nil {
+		stopper = ResultStopper
+	}
+
 	done := make(chan struct{})
+	// RemoveListner の後も配信中のリスナーが書き込むことがある
+	var mu sync.Mutex
 	lines := []string{}
 
 	listener := func(l []string) error {
 		if len(l) == 0 {
 			return nil
 		}
-		if stopper == nil {
-			if len(l) == 1 && l[0] == "" {
-				close(done)
-				return nil
-			}
-		} else {
-			if stopper(l) {
-				lines = append(lines, l...)
-				close(done)
-				return nil
-			}
+		mu.Lock()
+		defer mu.Unlock()
+
+		select {
+		case <-done:
+			return nil
+		default:
 		}
 		lines = append(lines, l...)
+		if stopper(l) {
+			close(done)
+		}
 		return nil
 	}
 
 	s.AddListner(&listener)
 
-	_, err := s.port.Write(command)
+	_, err := s.getPort().Write(command)
 	if err != nil {
+		s.RemoveListner(&listener)
 		return []string{}, err
 	}
 
 	select {
 	case <-ctx.Done():
 		s.RemoveListner(&listener)
-		return []string{}, ctx.Err()
+		mu.Lock()
+		defer mu.Unlock()
+
+		return slices.Clone(lines), ctx.Err()
 	case <-done:
 		s.RemoveListner(&listener)
+		mu.Lock()
+		defer mu.Unlock()
+
 		return lines, nil
 	}
 }
@@ -0,0 +1,440 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// コマンドをエコーバックし、handler が返した行を応答する偽のポート
+type fakePort struct {
+	mu      sync.Mutex
+	in      []uint8
+	out     bytes.Buffer
+	closed  bool
+	handler func(command string) []string
+	// 受信したコマンドの行
+	commands []string
+}
+
+func newFakePort(handler func(command string) []string) *fakePort {
+	return &fakePort{handler: handler}
+}
+
+func (p *fakePort) Read(b []uint8) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return 0, io.EOF
+	}
+	if p.out.Len() == 0 {
+		return 0, nil
+	}
+	// 読み込みの境界で行が分かれても扱えるよう、少しずつ返す
+	return p.out.Read(b[:min(len(b), 7)])
+}
+
+func (p *fakePort) Write(b []uint8) (int, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+	p.in = append(p.in, b...)
+	var commands []string
+	for {
+		i := bytes.Index(p.in, []uint8("\r\n"))
+		if i == -1 {
+			break
+		}
+		commands = append(commands, string(p.in[:i]))
+		p.in = p.in[i+2:]
+	}
+	p.commands = append(p.commands, commands...)
+	p.mu.Unlock()
+
+	for _, c := range commands {
+		p.emit(append([]string{c}, p.handler(c)...)...)
+	}
+	return len(b), nil
+}
+
+// コマンドと関係なく非同期に届く行を書き込みます。
+func (p *fakePort) emit(lines ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, l := range lines {
+		p.out.WriteString(l + "\r\n")
+	}
+}
+
+func (p *fakePort) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	return nil
+}
+
+func (p *fakePort) received() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.commands)
+}
+
+func startStreaming(t *testing.T, port io.ReadWriteCloser) *Serial {
+	t.Helper()
+
+	s := New(Config{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Port:   port,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	ready := make(chan struct{})
+	errCh := make(chan error, 1)
+	go s.Streaming(ctx, ready, errCh)
+	<-ready
+
+	t.Cleanup(func() {
+		cancel()
+		<-errCh
+	})
+	return s
+}
+
+// 並行に呼ばれた Exec が混ざらずに 1 つずつ実行され、それぞれの応答を受け取る
+func TestExecConcurrent(t *testing.T) {
+	port := newFakePort(func(command string) []string {
+		return []string{"RES " + strings.TrimPrefix(command, "CMD "), "OK"}
+	})
+	s := startStreaming(t, port)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			want := []string{fmt.Sprintf("CMD %d", i), fmt.Sprintf("RES %d", i), "OK"}
+			res, err := s.Exec(ctx, []uint8(want[0]+"\r\n"), nil)
+			if err != nil {
+				errs <- fmt.Errorf("Exec(%q): %w", want[0], err)
+				return
+			}
+			if !slices.Equal(res, want) {
+				errs <- fmt.Errorf("Exec(%q) = %q, want %q", want[0], res, want)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	commands := port.received()
+	if len(commands) != n {
+		t.Fatalf("received %d commands, want %d: %q", len(commands), n, commands)
+	}
+	for _, c := range commands {
+		if !strings.HasPrefix(c, "CMD ") {
+			t.Errorf("command interleaved on the wire: %q", c)
+		}
+	}
+}
+
+// Streaming が配信している間にリスナーを追加、削除しても競合しない
+func TestListenersWhileStreaming(t *testing.T) {
+	port := newFakePort(func(command string) []string { return []string{"OK"} })
+	s := startStreaming(t, port)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			port.emit(fmt.Sprintf("EVENT 21 FE80:0000:0000:0000:0000:0000:0000:%04X 00", i))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var received atomic.Int64
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for range 50 {
+				l := func(lines []string) error {
+					received.Add(1)
+					return nil
+				}
+				s.AddListner(&l)
+				time.Sleep(time.Millisecond)
+				s.RemoveListner(&l)
+			}
+		}()
+	}
+
+	// リスナーの登録と並行してコマンドも実行する
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for range 10 {
+		_, err := s.Exec(ctx, []uint8("SKINFO\r\n"), nil)
+		if err != nil {
+			t.Errorf("Exec: %v", err)
+		}
+	}
+
+	wg.Wait()
+	close(done)
+
+	if received.Load() == 0 {
+		t.Error("listeners received no lines")
+	}
+	if n := len(s.snapshot()); n != 0 {
+		t.Errorf("%d listeners left after removing all", n)
+	}
+}
+
+// コマンドの実行中に届いた非同期のイベントも、登録済みのリスナーに配信される
+func TestAsyncEventDuringExec(t *testing.T) {
+	const (
+		async     = "EVENT 21 FE80:0000:0000:0000:021D:1290:1234:5678 00"
+		connected = "EVENT 25 FE80:0000:0000:0000:021D:1290:1234:5678"
+	)
+
+	port := newFakePort(func(command string) []string {
+		if command != "SKJOIN" {
+			return []string{"OK"}
+		}
+		// OK の後、接続完了より先に関係ないイベントが届く
+		return []string{"OK", async, connected}
+	})
+	s := startStreaming(t, port)
+
+	var mu sync.Mutex
+	var events []string
+	l := func(lines []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		events = append(events, lines...)
+		return nil
+	}
+	s.AddListner(&l)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stopper := func(lines []string) bool {
+		return slices.ContainsFunc(lines, func(line string) bool { return strings.HasPrefix(line, "EVENT 25") })
+	}
+	res, err := s.Exec(ctx, []uint8("SKJOIN\r\n"), stopper)
+	if err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+
+	want := []string{"SKJOIN", "OK", async, connected}
+	if !slices.Equal(res, want) {
+		t.Errorf("Exec = %q, want %q", res, want)
+	}
+
+	// Exec が返った後に届いたイベントも受け取れる
+	port.emit(async)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		got := slices.Clone(events)
+		mu.Unlock()
+		if n >= len(want)+1 {
+			if !slices.Equal(got, append(want, async)) {
+				t.Errorf("listener received %q, want %q", got, append(want, async))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("listener received %q, want %q", got, append(want, async))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 受信した行を記録するリスナーを登録し、want の行数が揃うまで待って返します。
+func waitLines(t *testing.T, s *Serial, want int) func() []string {
+	t.Helper()
+
+	var mu sync.Mutex
+	var lines []string
+	l := func(l []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		lines = append(lines, l...)
+		return nil
+	}
+	s.AddListner(&l)
+
+	return func() []string {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			mu.Lock()
+			got := slices.Clone(lines)
+			mu.Unlock()
+			if len(got) >= want || time.Now().After(deadline) {
+				return got
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// 読み込みに失敗するポート
+type brokenPort struct {
+	fakePort
+}
+
+func (p *brokenPort) Read(b []uint8) (int, error) {
+	n, err := p.fakePort.Read(b)
+	if n == 0 && err == nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// ポートを開き直すと、リスナーに知らせて新しいポートのデータを最初の行から区切る
+func TestReopenNotifiesListeners(t *testing.T) {
+	broken := &brokenPort{}
+	broken.emit("OK")
+	next := newFakePort(nil)
+	next.emit("EVENT 21 FE80:0000:0000:0000:021D:1290:1234:5678 00")
+
+	s := New(Config{
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Port:   broken,
+		Reopen: func() (io.ReadWriteCloser, error) {
+			return next, nil
+		},
+	})
+	var reopened atomic.Int64
+	s.AddReopenListner(func() {
+		reopened.Add(1)
+	})
+	lines := waitLines(t, s, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := make(chan struct{})
+	go s.Streaming(ctx, ready, make(chan error, 1))
+	<-ready
+
+	want := []string{"OK", "EVENT 21 FE80:0000:0000:0000:021D:1290:1234:5678 00"}
+	if got := lines(); !slices.Equal(got, want) {
+		t.Errorf("listener received %q, want %q", got, want)
+	}
+	if n := reopened.Load(); n != 1 {
+		t.Errorf("reopen listeners called %d times, want 1", n)
+	}
+}
+
+// ser2net などに再接続した場合も開き直したことを知らせ、切断前の行の途中は捨てる
+func TestNetworkReconnectNotifiesListeners(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]uint8("OK\r\nEVENT 2"))
+		conn.Close()
+
+		conn, err = ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]uint8("OK\r\n"))
+		// テストが終わるまで接続を保つ
+		io.Copy(io.Discard, conn)
+	}()
+
+	port, err := openURL(&url.URL{Scheme: "tcp", Host: ln.Addr().String()}, 115200)
+	if err != nil {
+		t.Fatalf("openURL: %v", err)
+	}
+	s := New(Config{Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), Port: port})
+	defer s.Close()
+	var reopened atomic.Int64
+	s.AddReopenListner(func() {
+		reopened.Add(1)
+	})
+	lines := waitLines(t, s, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := make(chan struct{})
+	go s.Streaming(ctx, ready, make(chan error, 1))
+	<-ready
+
+	want := []string{"OK", "OK"}
+	if got := lines(); !slices.Equal(got, want) {
+		t.Errorf("listener received %q, want %q", got, want)
+	}
+	if n := reopened.Load(); n != 1 {
+		t.Errorf("reopen listeners called %d times, want 1", n)
+	}
+}
+
+// 応答が揃う前に時間切れになった場合も、それまでに受信した行を返す
+func TestExecTimeoutReturnsLines(t *testing.T) {
+	port := newFakePort(func(command string) []string {
+		// EVENT 25 が届かない
+		return []string{"OK"}
+	})
+	s := startStreaming(t, port)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	stopper := func(lines []string) bool {
+		return slices.ContainsFunc(lines, func(line string) bool { return strings.HasPrefix(line, "EVENT 25") })
+	}
+	res, err := s.Exec(ctx, []uint8("SKJOIN\r\n"), stopper)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Exec error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	want := []string{"SKJOIN", "OK"}
+	if !slices.Equal(res, want) {
+		t.Errorf("Exec = %q, want %q", res, want)
+	}
+}
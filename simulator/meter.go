@@ -0,0 +1,182 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/echonetlite/property/smartmeter"
+)
+
+var (
+	smartMeterEOJ = [3]uint8{smartmeter.ClassGroupCode, smartmeter.ClassCode, 0x01}
+	controllerEOJ = [3]uint8{0x05, 0xff, 0x01}
+)
+
+// 低圧スマート電力量メーターの応答を模擬します。
+// 積算電力量の単位は 0.1kWh、有効桁数は 6 桁です。
+type Meter struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	updatedAt time.Time
+	// 0.1kWh 単位の積算電力量、小数点以下も保持する
+	normal  float64
+	reverse float64
+}
+
+func NewMeter(now func() time.Time) *Meter {
+	if now == nil {
+		now = time.Now
+	}
+
+	return &Meter{
+		now:       now,
+		updatedAt: now(),
+		normal:    123456,
+		reverse:   789,
+	}
+}
+
+// 時刻に応じて 300W から 1500W の間で変化する瞬時電力
+func (m *Meter) power(t time.Time) float64 {
+	phase := float64(t.Unix()%600) / 600 * 2 * math.Pi
+	return 900 + 600*math.Sin(phase)
+}
+
+func (m *Meter) update() time.Time {
+	now := m.now()
+	elapsed := now.Sub(m.updatedAt).Hours()
+	// W * h / 100 = 0.1kWh
+	m.normal += m.power(now) * elapsed / 100
+	m.updatedAt = now
+	return now
+}
+
+func (m *Meter) cumulative(v float64) uint32 {
+	return uint32(uint64(v) % 1000000)
+}
+
+func dateBytes(t time.Time) []uint8 {
+	b := binary.BigEndian.AppendUint16(nil, uint16(t.Year()))
+	return append(b, uint8(t.Month()), uint8(t.Day()), uint8(t.Hour()), uint8(t.Minute()), uint8(t.Second()))
+}
+
+func (m *Meter) edt(epc property.EPC, now time.Time) []uint8 {
+	switch epc {
+	case smartmeter.EPCOperationStatus:
+		return []uint8{0x30}
+	case smartmeter.EPCRouteBIdentificationNumber:
+		return []uint8{0xFE, 0x00, 0x00, 0x16, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x12, 0x34, 0x56, 0x78}
+	case smartmeter.EPCOneMinuteMeasuredCumulativeAmountsOfElectricEnergyMeasured:
+		b := dateBytes(now.Truncate(time.Minute))
+		b = binary.BigEndian.AppendUint32(b, m.cumulative(m.normal))
+		return binary.BigEndian.AppendUint32(b, m.cumulative(m.reverse))
+	case smartmeter.EPCCoefficient:
+		return binary.BigEndian.AppendUint32(nil, 1)
+	case smartmeter.EPCNumberOfEffectiveDigitsForCumulativeAmountOfElectricEnergy:
+		return []uint8{6}
+	case smartmeter.EPCMeasuredCumulativeAmountOfElectricEnergyNormalDirection:
+		return binary.BigEndian.AppendUint32(nil, m.cumulative(m.normal))
+	case smartmeter.EPCUnitForCumulativeAmountOfElectricEnergy:
+		return []uint8{0x01}
+	case smartmeter.EPCMeasuredCumulativeAmountOfElectricEnergyReverseDirection:
+		return binary.BigEndian.AppendUint32(nil, m.cumulative(m.reverse))
+	case smartmeter.EPCMeasuredInstantaneousElectricPower:
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(m.power(now))))
+	case smartmeter.EPCMeasuredInstantaneousCurrents:
+		// 100V 単相 3 線式で R 相と T 相に均等に流れているものとする
+		a := uint16(m.power(now) / 200 * 10)
+		b := binary.BigEndian.AppendUint16(nil, a)
+		return binary.BigEndian.AppendUint16(b, a)
+	case smartmeter.EPCCumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection:
+		b := dateBytes(fixedTime(now))
+		return binary.BigEndian.AppendUint32(b, m.cumulative(m.normal))
+	case smartmeter.EPCCumulativeAmountOfElectricEnergyMeasuredAtFixedTimeReverseDirection:
+		b := dateBytes(fixedTime(now))
+		return binary.BigEndian.AppendUint32(b, m.cumulative(m.reverse))
+	default:
+		return nil
+	}
+}
+
+// 直近の 30 分毎の定時
+func fixedTime(t time.Time) time.Time {
+	return t.Truncate(30 * time.Minute)
+}
+
+// ECHONET Lite の要求フレームに対する応答フレームを返します。応答不要の場合は nil を返します。
+func (m *Meter) Handle(req []uint8) []uint8 {
+	f, err := echonetlite.NewFrame(req)
+	if err != nil {
+		return nil
+	}
+
+	if f.EDATA.ESV != echonetlite.ESVGet {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.update()
+
+	esv := echonetlite.ESVGet_Res
+	props := make([]property.Property, len(f.EDATA.Properties))
+	for i, p := range f.EDATA.Properties {
+		epc := p.ToSettable().EPC
+		edt := m.edt(epc, now)
+		if edt == nil {
+			esv = echonetlite.ESVGet_SNA
+			edt = []uint8{}
+		}
+		props[i] = property.NewUnknownProperty(property.RawProperty{EPC: epc, EDT: edt})
+	}
+
+	res := &echonetlite.Frame{
+		EHD1: echonetlite.EHD1ECHONETLite,
+		EHD2: echonetlite.EHD2SpecifiedMessageFormat,
+		TID:  f.TID,
+		EDATA: echonetlite.Data{
+			SEOJ:       smartMeterEOJ,
+			DEOJ:       f.EDATA.SEOJ,
+			ESV:        esv,
+			Properties: props,
+		},
+	}
+
+	return res.Bytes()
+}
+
+// 定時積算電力量計測値 (EA/EB) の通知フレームを返します。
+func (m *Meter) Notification() []uint8 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.update()
+
+	f := &echonetlite.Frame{
+		EHD1: echonetlite.EHD1ECHONETLite,
+		EHD2: echonetlite.EHD2SpecifiedMessageFormat,
+		TID:  [2]uint8{0x00, 0x00},
+		EDATA: echonetlite.Data{
+			SEOJ: smartMeterEOJ,
+			DEOJ: controllerEOJ,
+			ESV:  echonetlite.ESVINF,
+			Properties: []property.Property{
+				property.NewUnknownProperty(property.RawProperty{
+					EPC: smartmeter.EPCCumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection,
+					EDT: m.edt(smartmeter.EPCCumulativeAmountOfElectricEnergyMeasuredAtFixedTimeNormalDirection, now),
+				}),
+				property.NewUnknownProperty(property.RawProperty{
+					EPC: smartmeter.EPCCumulativeAmountOfElectricEnergyMeasuredAtFixedTimeReverseDirection,
+					EDT: m.edt(smartmeter.EPCCumulativeAmountOfElectricEnergyMeasuredAtFixedTimeReverseDirection, now),
+				}),
+			},
+		},
+	}
+
+	return f.Bytes()
+}
@@ -0,0 +1,807 @@
+// SKSTACK IP のテキストプロトコルを話す Wi-SUN モジュールのソフトウェア実装です。
+// serial.Config.Port に渡すことで、実機なしで MB_RL7023_11 を動かせます。
+package simulator
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+)
+
+var ErrClosed = errors.New("simulator closed")
+
+const (
+	defaultReadTimeout = 10 * time.Millisecond
+	defaultDelay       = 50 * time.Millisecond
+
+	Addr64      = "001D129000000001"
+	IPAddr      = "FE80:0000:0000:0000:021D:1290:0000:0001"
+	MeterAddr64 = "001D129012345678"
+	MeterIPAddr = "FE80:0000:0000:0000:021D:1290:1234:5678"
+	Channel     = 0x3B
+	PanID       = 0x1234
+	PairID      = "12345678"
+	UDPPort     = 0x0E1A
+	PANAPort    = 0x02CC
+)
+
+type output struct {
+	delay time.Duration
+	data  string
+}
+
+type Simulator struct {
+	delay       time.Duration
+	meter       *Meter
+	readTimeout time.Duration
+
+	mu        sync.Mutex
+	in        []uint8
+	out       bytes.Buffer
+	closed    bool
+	joined    bool
+	registers map[string]string
+	// TCP ハンドル番号ごとのコネクション
+	handles map[uint8]tcpHandle
+	// 送信時間の制限中、送信コマンドは OK だけ返して送信しない
+	txLimited bool
+	// ED スキャンの回数、回ごとに RSSI を変える
+	edScans int
+	opt     MB_RL7023_11.WOPTMode
+	uart    MB_RL7023_11.UARTMode
+	// ホスト側のボーレート、モジュールと一致しない場合は入力を読み捨てる
+	hostBaudRate int
+
+	notify chan struct{}
+	queue  chan output
+	done   chan struct{}
+}
+
+type Config struct {
+	// 応答を返すまでの待ち時間、default: 50ms
+	Delay time.Duration
+	// スマートメーター、default: NewMeter(nil)
+	Meter *Meter
+	// 0 以外の場合、参加中はこの間隔で定時積算電力量を通知する
+	NotifyInterval time.Duration
+	// データが無いときに Read が 0 バイトで戻るまでの時間、default: 10ms
+	ReadTimeout time.Duration
+	// モジュールの UART 設定、default: 0 (115200bps)
+	UART MB_RL7023_11.UARTMode
+	// ERXUDP のデータ部をバイナリ表示にする、default: false (16 進 ASCII 表示)
+	BinaryPayload bool
+}
+
+func New(c Config) *Simulator {
+	s := &Simulator{
+		delay:       c.Delay,
+		meter:       c.Meter,
+		readTimeout: c.ReadTimeout,
+		registers: map[string]string{
+			"S02": "21",
+			"S03": "FFFF",
+			"S07": "00000000",
+			"S0A": "CCDDEEFF",
+			"S15": "0",
+			"S16": "00000384",
+			"S17": "1",
+			"SA0": "1",
+			"SA1": "1",
+			"SFB": "0",
+			"SFD": "0000000000000000",
+			"SFE": "1",
+			"SFF": "0",
+		},
+		opt:          MB_RL7023_11.WOPTModeASCII,
+		handles:      map[uint8]tcpHandle{},
+		uart:         c.UART,
+		hostBaudRate: 115200,
+		notify:       make(chan struct{}, 1),
+		queue:        make(chan output, 64),
+		done:         make(chan struct{}),
+	}
+	if c.BinaryPayload {
+		s.opt = MB_RL7023_11.WOPTModeBinary
+	}
+	if s.delay == 0 {
+		s.delay = defaultDelay
+	}
+	if s.meter == nil {
+		s.meter = NewMeter(nil)
+	}
+	if s.readTimeout == 0 {
+		s.readTimeout = defaultReadTimeout
+	}
+
+	go s.run()
+	if c.NotifyInterval > 0 {
+		go s.notifyLoop(c.NotifyInterval)
+	}
+
+	return s
+}
+
+// キューに積まれた出力を順番に、それぞれの待ち時間をおいて書き出します。
+func (s *Simulator) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case o := <-s.queue:
+			if o.delay > 0 {
+				select {
+				case <-s.done:
+					return
+				case <-time.After(o.delay):
+				}
+			}
+
+			s.mu.Lock()
+			s.out.WriteString(o.data)
+			s.mu.Unlock()
+
+			select {
+			case s.notify <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (s *Simulator) notifyLoop(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			joined := s.joined
+			s.mu.Unlock()
+			if joined {
+				s.emit(s.delay, s.erxudp(s.meter.Notification()))
+			}
+		}
+	}
+}
+
+func (s *Simulator) emit(delay time.Duration, lines ...string) {
+	select {
+	case <-s.done:
+	case s.queue <- output{delay: delay, data: strings.Join(lines, "\r\n") + "\r\n"}:
+	}
+}
+
+// データがあれば読み出します。無い場合は ReadTimeout だけ待って 0 バイトで戻ります。
+func (s *Simulator) Read(p []uint8) (int, error) {
+	t := time.NewTimer(s.readTimeout)
+	defer t.Stop()
+
+	for {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return 0, ErrClosed
+		}
+		if s.out.Len() > 0 {
+			n, _ := s.out.Read(p)
+			s.mu.Unlock()
+			return n, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.notify:
+		case <-t.C:
+			return 0, nil
+		}
+	}
+}
+
+func (s *Simulator) Write(p []uint8) (int, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return 0, ErrClosed
+	}
+	if s.hostBaudRate != s.uart.BaudRate() {
+		s.mu.Unlock()
+		return len(p), nil
+	}
+	s.in = append(s.in, p...)
+	var commands []command
+	for {
+		c, ok := s.next()
+		if !ok {
+			break
+		}
+		commands = append(commands, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range commands {
+		s.handle(c)
+	}
+
+	return len(p), nil
+}
+
+// ホスト側のボーレートを変更します。
+func (s *Simulator) SetBaudRate(baudRate int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hostBaudRate = baudRate
+	// ボーレートが変わる前に受け付けていた途中の入力は壊れている
+	s.in = nil
+	return nil
+}
+
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	return nil
+}
+
+type command struct {
+	line    string
+	payload []uint8
+}
+
+// データ長を伴うコマンドのヘッダーに含まれるフィールド数
+var payloadCommands = map[string]int{
+	"SKSENDTO": 7,
+	"SKSEND":   3,
+}
+
+// 入力バッファから完全なコマンドを 1 つ取り出します。
+func (s *Simulator) next() (command, bool) {
+	for name, fields := range payloadCommands {
+		if !bytes.HasPrefix(s.in, []uint8(name+" ")) {
+			continue
+		}
+
+		// ヘッダーの最後の空白までを探す
+		end := -1
+		spaces := 0
+		for i, b := range s.in {
+			if b == '\r' || b == '\n' {
+				break
+			}
+			if b == ' ' {
+				spaces++
+				if spaces == fields {
+					end = i
+					break
+				}
+			}
+		}
+		if end == -1 {
+			break
+		}
+
+		header := strings.Fields(string(s.in[:end]))
+		length, err := strconv.ParseUint(header[len(header)-1], 16, 16)
+		if err != nil {
+			break
+		}
+		if len(s.in) < end+1+int(length) {
+			return command{}, false
+		}
+
+		c := command{
+			line:    string(s.in[:end+1]),
+			payload: bytes.Clone(s.in[end+1 : end+1+int(length)]),
+		}
+		s.in = s.in[end+1+int(length):]
+		return c, true
+	}
+
+	i := bytes.Index(s.in, []uint8("\r\n"))
+	if i == -1 {
+		return command{}, false
+	}
+	c := command{line: string(s.in[:i])}
+	s.in = s.in[i+2:]
+	return c, true
+}
+
+func (s *Simulator) handle(c command) {
+	if c.line == "" {
+		return
+	}
+
+	s.mu.Lock()
+	echoback := s.registers["SFE"] == "1"
+	s.mu.Unlock()
+	if echoback {
+		s.emit(0, c.line)
+	}
+
+	fields := strings.Fields(c.line)
+	switch fields[0] {
+	case "SKRESET":
+		s.mu.Lock()
+		s.joined = false
+		clear(s.handles)
+		s.mu.Unlock()
+		s.emit(s.delay, "OK")
+
+	case "SKVER":
+		s.emit(s.delay, "EVER 1.2.10", "OK")
+
+	case "SKAPPVER":
+		s.emit(s.delay, "EAPPVER simulator", "OK")
+
+	case "SKINFO":
+		s.mu.Lock()
+		channel, panid := s.registers["S02"], s.registers["S03"]
+		s.mu.Unlock()
+		s.emit(s.delay, fmt.Sprintf("EINFO %s %s %s %s FFFE", IPAddr, Addr64, channel, panid), "OK")
+
+	case "SKSETRBID", "SKSETPWD", "SKSETPSK", "SKSETKEY", "SKRMKEY", "SKREGDEV", "SKRMDEV",
+		"SKSECENABLE", "SKADDNBR", "SKUDPPORT", "SKTCPPORT", "SKSAVE", "SKLOAD", "SKERASE":
+		s.emit(s.delay, "OK")
+
+	case "RUART":
+		s.mu.Lock()
+		uart := s.uart
+		s.mu.Unlock()
+		s.emit(s.delay, "OK "+uart.String())
+
+	case "WUART":
+		if len(fields) != 2 {
+			s.emit(s.delay, "FAIL ER05")
+			return
+		}
+		v, err := strconv.ParseUint(fields[1], 16, 8)
+		if err != nil || MB_RL7023_11.UARTMode(v).BaudRate() == 0 {
+			s.emit(s.delay, "FAIL ER06")
+			return
+		}
+		s.emit(s.delay, "OK")
+		s.mu.Lock()
+		s.uart = MB_RL7023_11.UARTMode(v)
+		s.mu.Unlock()
+
+	case "ROPT":
+		s.mu.Lock()
+		opt := s.opt
+		s.mu.Unlock()
+		s.emit(s.delay, fmt.Sprintf("OK %02X", uint8(opt)))
+
+	case "WOPT":
+		if len(fields) != 2 {
+			s.emit(s.delay, "FAIL ER05")
+			return
+		}
+		v, err := strconv.ParseUint(fields[1], 16, 8)
+		if err != nil || v > uint64(MB_RL7023_11.WOPTModeASCII) {
+			s.emit(s.delay, "FAIL ER06")
+			return
+		}
+		s.mu.Lock()
+		s.opt = MB_RL7023_11.WOPTMode(v)
+		s.mu.Unlock()
+		s.emit(s.delay, "OK")
+
+	case "SKSREG":
+		s.sreg(fields)
+
+	case "SKTABLE":
+		s.table(fields)
+
+	case "SKLL64":
+		if len(fields) != 2 {
+			s.emit(s.delay, "FAIL ER05")
+			return
+		}
+		s.emit(s.delay, ll64(fields[1]))
+
+	case "SKSCAN":
+		s.scan(fields)
+
+	case "SKJOIN":
+		if len(fields) != 2 {
+			s.emit(s.delay, "FAIL ER05")
+			return
+		}
+		s.emit(s.delay, "OK")
+		if fields[1] != MeterIPAddr {
+			s.emit(s.delay*4, fmt.Sprintf("EVENT 24 %s 00", fields[1]))
+			return
+		}
+		s.mu.Lock()
+		s.joined = true
+		s.mu.Unlock()
+		s.emit(s.delay*4, fmt.Sprintf("EVENT 25 %s 00", fields[1]))
+
+	case "SKREJOIN":
+		s.mu.Lock()
+		joined := s.joined
+		s.mu.Unlock()
+		s.emit(s.delay, "OK")
+		if !joined {
+			s.emit(s.delay*4, fmt.Sprintf("EVENT 24 %s 00", MeterIPAddr))
+			return
+		}
+		s.emit(s.delay*4, fmt.Sprintf("EVENT 25 %s 00", MeterIPAddr))
+
+	case "SKTERM":
+		s.mu.Lock()
+		joined := s.joined
+		s.joined = false
+		s.mu.Unlock()
+		if !joined {
+			s.emit(s.delay, "FAIL ER10")
+			return
+		}
+		s.emit(s.delay, "OK")
+		s.emit(s.delay, fmt.Sprintf("EVENT 27 %s 00", MeterIPAddr))
+
+	case "SKSENDTO":
+		s.sendto(fields, c.payload)
+
+	case "SKCONNECT":
+		s.connect(fields)
+
+	case "SKSEND":
+		s.send(fields, c.payload)
+
+	case "SKCLOSE":
+		s.closeTCP(fields)
+
+	case "SKPING":
+		if len(fields) < 2 {
+			s.emit(s.delay, "FAIL ER05")
+			return
+		}
+		s.emit(s.delay, "OK")
+		s.emit(s.delay, "EPONG "+fields[len(fields)-1])
+
+	default:
+		s.emit(s.delay, "FAIL ER04")
+	}
+}
+
+func (s *Simulator) sreg(fields []string) {
+	if len(fields) < 2 || len(fields) > 3 {
+		s.emit(s.delay, "FAIL ER05")
+		return
+	}
+
+	reg := strings.ToUpper(fields[1])
+
+	s.mu.Lock()
+	val, ok := s.registers[reg]
+	if ok && len(fields) == 3 {
+		s.registers[reg] = strings.ToUpper(fields[2])
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		s.emit(s.delay, "FAIL ER06")
+	case len(fields) == 3:
+		s.emit(s.delay, "OK")
+	default:
+		s.emit(s.delay, "ESREG "+val, "OK")
+	}
+}
+
+func (s *Simulator) table(fields []string) {
+	if len(fields) != 2 {
+		s.emit(s.delay, "FAIL ER05")
+		return
+	}
+
+	switch strings.ToUpper(fields[1]) {
+	case "1":
+		s.emit(s.delay, "EADDR", IPAddr, "OK")
+	case "2":
+		s.mu.Lock()
+		joined := s.joined
+		s.mu.Unlock()
+		lines := []string{"ENEIGHBOR"}
+		if joined {
+			lines = append(lines, fmt.Sprintf("%s %s FFFF", MeterIPAddr, MeterAddr64))
+		}
+		s.emit(s.delay, append(lines, "OK")...)
+	case "E":
+		s.emit(s.delay,
+			"EPORT",
+			strconv.Itoa(UDPPort), strconv.Itoa(PANAPort), "0", "0", "0", "0",
+			"",
+			"0", "0", "0", "0",
+			"OK",
+		)
+	case "F":
+		s.mu.Lock()
+		lines := []string{"EHANDLE"}
+		for h := uint8(1); h <= maxTCPHandles; h++ {
+			if c, ok := s.handles[h]; ok {
+				lines = append(lines, fmt.Sprintf("%X %s %04X %04X", h, c.ipaddr, c.rport, c.lport))
+			}
+		}
+		s.mu.Unlock()
+		s.emit(s.delay, append(lines, "OK")...)
+	default:
+		s.emit(s.delay, "FAIL ER06")
+	}
+}
+
+func (s *Simulator) scan(fields []string) {
+	if len(fields) < 4 {
+		s.emit(s.delay, "FAIL ER05")
+		return
+	}
+
+	s.emit(s.delay, "OK")
+
+	switch fields[1] {
+	case "0":
+		s.mu.Lock()
+		n := s.edScans
+		s.edScans++
+		s.mu.Unlock()
+
+		var pairs []string
+		for ch := 0x21; ch <= 0x3C; ch++ {
+			rssi := 0x20 + (ch*7)%0x30 + (n*ch)%5
+			pairs = append(pairs, fmt.Sprintf("%02X %02X", ch, rssi))
+		}
+		s.emit(s.delay*4, "EEDSCAN", strings.Join(pairs, " "))
+		s.emit(s.delay, fmt.Sprintf("EVENT 1F %s 00", IPAddr))
+
+	case "2", "3":
+		s.emit(s.delay*4, fmt.Sprintf("EVENT 20 %s 00", MeterIPAddr))
+		s.emit(s.delay,
+			"EPANDESC",
+			fmt.Sprintf("  Channel:%02X", Channel),
+			"  Channel Page:09",
+			fmt.Sprintf("  Pan ID:%04X", PanID),
+			"  Addr:"+MeterAddr64,
+			"  LQI:E1",
+			"  Side:0",
+			"  PairID:"+PairID,
+		)
+		s.emit(s.delay*4, fmt.Sprintf("EVENT 22 %s 00", IPAddr))
+
+	default:
+		s.emit(s.delay, "FAIL ER06")
+	}
+}
+
+func (s *Simulator) sendto(fields []string, payload []uint8) {
+	if len(fields) != 7 {
+		s.emit(s.delay, "FAIL ER05")
+		return
+	}
+
+	s.mu.Lock()
+	joined := s.joined
+	limited := s.txLimited
+	if !limited {
+		s.transmit(len(payload))
+	}
+	s.mu.Unlock()
+
+	dest := fields[2]
+	if limited {
+		s.emit(s.delay, "OK")
+		return
+	}
+	if !joined || dest != MeterIPAddr {
+		s.emit(s.delay, fmt.Sprintf("EVENT 21 %s 01", IPAddr), "OK")
+		return
+	}
+
+	s.emit(s.delay, fmt.Sprintf("EVENT 21 %s 00", IPAddr), "OK")
+
+	res := s.meter.Handle(payload)
+	if res == nil {
+		return
+	}
+	s.emit(s.delay*2, s.erxudp(res))
+}
+
+// TCP で接続できるハンドルの数
+const maxTCPHandles = 6
+
+type tcpHandle struct {
+	ipaddr string
+	rport  uint16
+	lport  uint16
+}
+
+// スマートメーターの TCP は受信したデータをそのまま送り返します。
+func (s *Simulator) connect(fields []string) {
+	if len(fields) != 4 {
+		s.emit(s.delay, "FAIL ER05")
+		return
+	}
+	rport, err := strconv.ParseUint(fields[2], 16, 16)
+	if err != nil {
+		s.emit(s.delay, "FAIL ER06")
+		return
+	}
+	lport, err := strconv.ParseUint(fields[3], 16, 16)
+	if err != nil {
+		s.emit(s.delay, "FAIL ER06")
+		return
+	}
+	c := tcpHandle{ipaddr: fields[1], rport: uint16(rport), lport: uint16(lport)}
+
+	s.mu.Lock()
+	joined := s.joined
+	handle := uint8(0)
+	used := false
+	for h := uint8(1); h <= maxTCPHandles; h++ {
+		e, ok := s.handles[h]
+		if !ok && handle == 0 {
+			handle = h
+		}
+		if ok && e.lport == c.lport {
+			used = true
+		}
+	}
+	if joined && !used && handle != 0 && c.ipaddr == MeterIPAddr {
+		s.handles[handle] = c
+	}
+	s.mu.Unlock()
+
+	s.emit(s.delay, "OK")
+	switch {
+	case used:
+		s.emit(s.delay*4, "ETCP 4 0")
+	case !joined || handle == 0 || c.ipaddr != MeterIPAddr:
+		s.emit(s.delay*4, "ETCP 3 0")
+	default:
+		s.emit(s.delay*4, fmt.Sprintf("ETCP 1 %X %s %04X %04X", handle, c.ipaddr, c.rport, c.lport))
+	}
+}
+
+func (s *Simulator) send(fields []string, payload []uint8) {
+	if len(fields) != 3 {
+		s.emit(s.delay, "FAIL ER05")
+		return
+	}
+	h, err := strconv.ParseUint(fields[1], 16, 8)
+	if err != nil {
+		s.emit(s.delay, "FAIL ER06")
+		return
+	}
+
+	s.mu.Lock()
+	c, ok := s.handles[uint8(h)]
+	limited := s.txLimited
+	if ok && !limited {
+		s.transmit(len(payload))
+	}
+	s.mu.Unlock()
+	if !ok {
+		s.emit(s.delay, "FAIL ER10")
+		return
+	}
+	if limited {
+		s.emit(s.delay, "OK")
+		return
+	}
+
+	s.emit(s.delay, "OK", fmt.Sprintf("ETCP 5 %X", h))
+	s.emit(s.delay*2, s.erxtcp(c, payload))
+}
+
+func (s *Simulator) closeTCP(fields []string) {
+	if len(fields) != 2 {
+		s.emit(s.delay, "FAIL ER05")
+		return
+	}
+	h, err := strconv.ParseUint(fields[1], 16, 8)
+	if err != nil {
+		s.emit(s.delay, "FAIL ER06")
+		return
+	}
+
+	s.mu.Lock()
+	_, ok := s.handles[uint8(h)]
+	delete(s.handles, uint8(h))
+	s.mu.Unlock()
+	if !ok {
+		s.emit(s.delay, "FAIL ER10")
+		return
+	}
+
+	s.emit(s.delay, "OK")
+	s.emit(s.delay, fmt.Sprintf("ETCP 3 %X", h))
+}
+
+// PANA セッションのライフタイムが切れたことにして、EVENT 29 を通知します。
+func (s *Simulator) ExpireSession() {
+	s.mu.Lock()
+	joined := s.joined
+	s.joined = false
+	s.mu.Unlock()
+
+	if joined {
+		s.emit(s.delay*4, fmt.Sprintf("EVENT 29 %s", MeterIPAddr))
+	}
+}
+
+// 送信時間の制限を設定し、EVENT 32 または 33 を通知します。
+func (s *Simulator) SetTransmitLimited(limited bool) {
+	s.mu.Lock()
+	changed := s.txLimited != limited
+	s.txLimited = limited
+	if limited {
+		s.registers["SFB"] = "1"
+	} else {
+		s.registers["SFB"] = "0"
+	}
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if limited {
+		s.emit(s.delay*4, fmt.Sprintf("EVENT 32 %s", IPAddr))
+	} else {
+		s.emit(s.delay*4, fmt.Sprintf("EVENT 33 %s", IPAddr))
+	}
+}
+
+// 100kbps で n バイト送信した時間を積算送信時間に加えます。s.mu を保持して呼び出します。
+func (s *Simulator) transmit(n int) {
+	total, _ := strconv.ParseUint(s.registers["SFD"], 16, 64)
+	// 1 バイトあたり 0.08ms、ヘッダーなどの分を切り上げる
+	total += uint64(n*8/100) + 1
+	s.registers["SFD"] = fmt.Sprintf("%016X", total)
+}
+
+func (s *Simulator) erxtcp(c tcpHandle, data []uint8) string {
+	line := fmt.Sprintf("ERXDATA %s %04X %04X %04X ", c.ipaddr, c.rport, c.lport, len(data))
+	return line + s.payload(data)
+}
+
+func (s *Simulator) erxudp(data []uint8) string {
+	line := fmt.Sprintf("ERXUDP %s %s %04X %04X %s 1 0 %04X ", MeterIPAddr, IPAddr, UDPPort, UDPPort, MeterAddr64, len(data))
+	return line + s.payload(data)
+}
+
+// WOPT の設定に合わせてデータ部を表示します。
+func (s *Simulator) payload(data []uint8) string {
+	s.mu.Lock()
+	opt := s.opt
+	s.mu.Unlock()
+
+	if opt == MB_RL7023_11.WOPTModeBinary {
+		return string(data)
+	}
+	return fmt.Sprintf("%X", data)
+}
+
+// MAC アドレスからリンクローカルアドレスを求めます。
+func ll64(addr64 string) string {
+	v, err := strconv.ParseUint(addr64, 16, 64)
+	if err != nil {
+		return "FAIL ER06"
+	}
+	v ^= 0x0200000000000000
+
+	return fmt.Sprintf("FE80:0000:0000:0000:%04X:%04X:%04X:%04X", uint16(v>>48), uint16(v>>32), uint16(v>>16), uint16(v))
+}
@@ -0,0 +1,292 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
+)
+
+const (
+	defaultInitialBackoff = 1 * time.Second
+	defaultMaxBackoff     = 5 * time.Minute
+	watchBufferSize       = 4
+	// 自動再認証しない場合、ライフタイムのこの割合を残して再認証する
+	renewMarginRatio = 10
+)
+
+// PANA セッションの接続状態
+type State uint8
+
+const (
+	StateDisconnected State = iota
+	StateJoining
+	StateRejoining
+	StateConnected
+	StateBackoff
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateJoining:
+		return "joining"
+	case StateRejoining:
+		return "rejoining"
+	case StateConnected:
+		return "connected"
+	case StateBackoff:
+		return "backoff"
+	default:
+		return "unknown"
+	}
+}
+
+type Supervisor struct {
+	initialBackoff  time.Duration
+	join            func(ctx context.Context) error
+	logger          *slog.Logger
+	maxBackoff      time.Duration
+	module          module.Module
+	onStateChange   func(State)
+	onSessionChange func(MB_RL7023_11.SessionStateChange)
+
+	// 接続に成功したセッションの情報を Watch に渡す
+	connected chan MB_RL7023_11.Session
+	// セッションが失われたことを Lost の受け手に知らせる
+	lost chan struct{}
+
+	mu    sync.Mutex
+	state State
+	// モジュールの状態が失われたため、SKREJOIN ではなく初期化から始める必要がある
+	moduleReset bool
+}
+
+type Config struct {
+	// 再試行間隔の初期値、default: 1s
+	InitialBackoff time.Duration
+	// SKRESET 後に PAN へ参加するまでの手順
+	Join   func(ctx context.Context) error
+	Logger *slog.Logger
+	// 再試行間隔の上限、default: 5m
+	MaxBackoff    time.Duration
+	Module        module.Module
+	OnStateChange func(State)
+	// PANA セッションの状態が変化したときに Watch から呼ばれる
+	OnSessionChange func(MB_RL7023_11.SessionStateChange)
+}
+
+func New(c Config) *Supervisor {
+	initialBackoff := c.InitialBackoff
+	if initialBackoff == 0 {
+		initialBackoff = defaultInitialBackoff
+	}
+	maxBackoff := c.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	return &Supervisor{
+		initialBackoff:  initialBackoff,
+		join:            c.Join,
+		logger:          c.Logger,
+		maxBackoff:      maxBackoff,
+		module:          c.Module,
+		onStateChange:   c.OnStateChange,
+		onSessionChange: c.OnSessionChange,
+		connected:       make(chan MB_RL7023_11.Session, 1),
+		lost:            make(chan struct{}, 1),
+		state:           StateDisconnected,
+	}
+}
+
+func (s *Supervisor) State() State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state
+}
+
+func (s *Supervisor) setState(state State) {
+	s.mu.Lock()
+	prev := s.state
+	s.state = state
+	s.mu.Unlock()
+
+	if prev == state {
+		return
+	}
+
+	s.logger.Info("Session state changed", "from", prev, "to", state)
+	if s.onStateChange != nil {
+		s.onStateChange(state)
+	}
+
+	if state == StateDisconnected {
+		select {
+		case s.lost <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// セッションが失われて Recover が必要になると受信できるチャンネルを返します。
+// 通知はまとめられるので、受信した後は State で現在の状態を確かめてください。
+func (s *Supervisor) Lost() <-chan struct{} {
+	return s.lost
+}
+
+// 通信の失敗などでセッションが失われたことを記録します。次の Recover で復旧します。
+func (s *Supervisor) Disconnected() {
+	s.setState(StateDisconnected)
+}
+
+// PANA セッションの終了とモジュールのリセットを、コンテキストが終了するまで監視します。
+// 自動再認証が無効な場合は、ライフタイムが切れる前に Disconnected にして Recover で再認証させます。
+func (s *Supervisor) Watch(ctx context.Context) {
+	sub := s.module.SubscribeSession(watchBufferSize)
+	defer sub.Close()
+	reset := s.module.SubscribeModuleReset(1)
+	defer reset.Close()
+
+	// 再認証する時刻になると送られる、不要な場合は nil
+	var renew <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-sub.C:
+			if s.onSessionChange != nil {
+				s.onSessionChange(*c)
+			}
+			if c.To != MB_RL7023_11.SessionStateClosed && c.To != MB_RL7023_11.SessionStateFailed {
+				continue
+			}
+			renew = nil
+			if s.State() == StateConnected {
+				args := []any{"state", c.To}
+				if c.Event != nil {
+					args = append(args, "event", c.Event.Num)
+				}
+				s.logger.Warn("PANA session lost", args...)
+				s.setState(StateDisconnected)
+			}
+		case session := <-s.connected:
+			renew = nil
+			expires := session.ExpiresAt()
+			if session.AutoReAuthentication || expires.IsZero() {
+				continue
+			}
+			renew = time.After(time.Until(expires) - session.Lifetime/renewMarginRatio)
+		case <-renew:
+			renew = nil
+			if s.State() == StateConnected {
+				s.logger.Info("PANA session is expiring, reauthenticating")
+				s.setState(StateDisconnected)
+			}
+		case <-reset.C:
+			renew = nil
+			s.logger.Warn("Wi-SUN module was reset")
+			s.mu.Lock()
+			s.moduleReset = true
+			s.mu.Unlock()
+			s.setState(StateDisconnected)
+		}
+	}
+}
+
+// 接続に成功したことを記録し、Watch にセッションの情報を渡します。
+func (s *Supervisor) established() {
+	s.setState(StateConnected)
+
+	select {
+	case <-s.connected:
+	default:
+	}
+	s.connected <- s.module.Session()
+}
+
+// PAN へ参加します。失敗した場合はモジュールを初期化して、成功するかコンテキストが終了するまで再試行します。
+// 引数の誤りなど、やり直しても成功しないコマンドのエラーの場合は再試行せずに返します。
+func (s *Supervisor) Connect(ctx context.Context) error {
+	backoff := s.initialBackoff
+	for {
+		s.setState(StateJoining)
+		err := s.join(ctx)
+		if err == nil {
+			s.established()
+			return nil
+		}
+		s.logger.Error("Failed to join to PAN", "err", err)
+		if fatal(err) {
+			s.setState(StateDisconnected)
+			return err
+		}
+
+		err = s.wait(ctx, &backoff)
+		if err != nil {
+			return err
+		}
+
+		err = s.module.Initialize(ctx)
+		if err != nil {
+			s.logger.Error("Failed to initialize Wi-SUN module", "err", err)
+		}
+	}
+}
+
+// セッションを復旧します。まず SKREJOIN で再認証を試み、失敗した場合は初期化からやり直します。
+// モジュールがリセットされていた場合は SKREJOIN を省きます。
+func (s *Supervisor) Recover(ctx context.Context) error {
+	s.mu.Lock()
+	moduleReset := s.moduleReset
+	s.moduleReset = false
+	s.mu.Unlock()
+
+	if !moduleReset {
+		s.setState(StateRejoining)
+		err := s.module.SKREJOIN(ctx)
+		if err == nil {
+			s.established()
+			return nil
+		}
+		s.logger.Warn("Failed to rejoin to PAN, reinitializing", "err", err)
+	}
+
+	err := s.module.Initialize(ctx)
+	if err != nil {
+		s.logger.Error("Failed to initialize Wi-SUN module", "err", err)
+	}
+
+	return s.Connect(ctx)
+}
+
+func (s *Supervisor) wait(ctx context.Context, backoff *time.Duration) error {
+	s.setState(StateBackoff)
+	s.logger.Info("Waiting before retry", "backoff", *backoff)
+
+	t := time.NewTimer(*backoff)
+	defer t.Stop()
+
+	*backoff = min(*backoff*2, s.maxBackoff)
+
+	select {
+	case <-ctx.Done():
+		s.setState(StateDisconnected)
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// やり直しても成功しないコマンドのエラーかを返します。
+func fatal(err error) bool {
+	var ce *MB_RL7023_11.CommandError
+	return errors.As(err, &ce) && !ce.Retryable()
+}
@@ -0,0 +1,112 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
+)
+
+type fakeModule struct {
+	module.Module
+	initialized int
+}
+
+func (m *fakeModule) Initialize(ctx context.Context) error {
+	m.initialized++
+	return nil
+}
+
+func (m *fakeModule) Session() MB_RL7023_11.Session {
+	return MB_RL7023_11.Session{}
+}
+
+func newTestSupervisor(m module.Module, join func(ctx context.Context) error) *Supervisor {
+	return New(Config{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		Join:           join,
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Module:         m,
+	})
+}
+
+// ポートの再接続中などの通信路のエラーでは諦めずに再試行する
+func TestConnectRetriesTransportError(t *testing.T) {
+	m := &fakeModule{}
+	attempts := 0
+	s := newTestSupervisor(m, func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return &MB_RL7023_11.CommandError{Command: "SKJOIN", Err: io.ErrClosedPipe}
+		}
+		return nil
+	})
+
+	err := s.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect() = %v, want nil", err)
+	}
+	if attempts != 3 {
+		t.Errorf("attempts = %d, want 3", attempts)
+	}
+	if s.State() != StateConnected {
+		t.Errorf("State() = %s, want %s", s.State(), StateConnected)
+	}
+}
+
+// 引数の誤りはやり直しても成功しないので再試行しない
+func TestConnectGivesUpOnInvalidParameter(t *testing.T) {
+	m := &fakeModule{}
+	attempts := 0
+	s := newTestSupervisor(m, func(ctx context.Context) error {
+		attempts++
+		return &MB_RL7023_11.CommandError{Command: "SKSETRBID ****", Code: MB_RL7023_11.ErrorCodeInvalidParameter, Err: MB_RL7023_11.ErrInvalidParameter}
+	})
+
+	err := s.Connect(context.Background())
+	if !errors.Is(err, MB_RL7023_11.ErrInvalidParameter) {
+		t.Fatalf("Connect() = %v, want %v", err, MB_RL7023_11.ErrInvalidParameter)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+// セッションが失われると、次の取得を待っているループにすぐ知らせる
+func TestLost(t *testing.T) {
+	s := newTestSupervisor(&fakeModule{}, func(ctx context.Context) error {
+		return nil
+	})
+
+	err := s.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("Connect() = %v, want nil", err)
+	}
+	select {
+	case <-s.Lost():
+		t.Fatal("Lost() received while connected")
+	default:
+	}
+
+	s.Disconnected()
+	// 通知はまとめられる
+	s.Disconnected()
+	s.setState(StateBackoff)
+	s.setState(StateDisconnected)
+	select {
+	case <-s.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("Lost() not received after Disconnected")
+	}
+	select {
+	case <-s.Lost():
+		t.Fatal("Lost() received twice for coalesced changes")
+	default:
+	}
+}
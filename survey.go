@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
+)
+
+const (
+	minSurveyChannel = 0x21
+	maxSurveyChannel = 0x3C
+	// 0x21 から 0x3C までのチャンネルマスク
+	surveyChannelMask = 1<<(maxSurveyChannel-minSurveyChannel+1) - 1
+	// 1 チャンネルあたりのスキャン時間
+	surveyDuration = 4
+	// 失敗したスキャンをやり直すまでの待ち時間
+	surveyInitialBackoff = 1 * time.Second
+	surveyMaxBackoff     = 1 * time.Minute
+	// 続けてこの回数失敗したら諦める
+	surveyMaxFailures = 5
+)
+
+// チャンネルごとの RSSI (dBm) の集計
+type channelStats struct {
+	Channel uint8   `json:"channel"`
+	Samples int     `json:"samples"`
+	Min     float64 `json:"min_dbm"`
+	Avg     float64 `json:"avg_dbm"`
+	Max     float64 `json:"max_dbm"`
+
+	sum float64
+}
+
+func (c *channelStats) add(dbm float64) {
+	if c.Samples == 0 || dbm < c.Min {
+		c.Min = dbm
+	}
+	if c.Samples == 0 || dbm > c.Max {
+		c.Max = dbm
+	}
+	c.Samples++
+	c.sum += dbm
+	c.Avg = c.sum / float64(c.Samples)
+}
+
+// ED スキャンを繰り返した結果
+type survey struct {
+	Rounds   int             `json:"rounds"`
+	Channels []*channelStats `json:"channels"`
+
+	rssi *prometheus.GaugeVec
+}
+
+func newSurvey() *survey {
+	s := &survey{}
+	for ch := minSurveyChannel; ch <= maxSurveyChannel; ch++ {
+		s.Channels = append(s.Channels, &channelStats{Channel: uint8(ch)})
+	}
+	return s
+}
+
+// 集計結果をメトリクスとして公開します。
+func (s *survey) register(r prometheus.Registerer) error {
+	s.rssi = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "smartmeter",
+			Name:      "survey_rssi_dbm",
+			Help:      "RSSI per channel measured by ED scans in survey mode.",
+		},
+		[]string{"channel", "stat"},
+	)
+	return r.Register(s.rssi)
+}
+
+func (s *survey) add(e *MB_RL7023_11.EEDSCAN) {
+	for _, r := range e.Result {
+		if r.Channel < minSurveyChannel || r.Channel > maxSurveyChannel {
+			continue
+		}
+		c := s.Channels[r.Channel-minSurveyChannel]
+		c.add(r.DBm())
+
+		if s.rssi != nil {
+			ch := strconv.FormatUint(uint64(c.Channel), 16)
+			s.rssi.WithLabelValues(ch, "min").Set(c.Min)
+			s.rssi.WithLabelValues(ch, "avg").Set(c.Avg)
+			s.rssi.WithLabelValues(ch, "max").Set(c.Max)
+		}
+	}
+}
+
+// ED スキャンを rounds 回繰り返して集計します。rounds が 0 の場合はコンテキストが終了するまで続けます。
+// コンテキストが終了した場合は、それまでの集計を返します。
+// やり直せるエラーは待ってからやり直し、surveyMaxFailures 回続けて失敗した場合はそのエラーを返します。
+func (s *survey) run(ctx context.Context, mb module.Module, rounds int, logger *slog.Logger) error {
+	backoff := surveyInitialBackoff
+	failures := 0
+	for rounds == 0 || s.Rounds < rounds {
+		res, err := mb.SKSCAN(ctx, MB_RL7023_11.SKSCANModeEDScan, surveyChannelMask, surveyDuration, MB_RL7023_11.SKSCANReservedValue)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			failures++
+			if !MB_RL7023_11.Retryable(err) || failures >= surveyMaxFailures {
+				return err
+			}
+			logger.Warn("ED scan failed, retrying", "err", err, "failures", failures, "backoff", backoff)
+
+			t := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return nil
+			case <-t.C:
+			}
+			backoff = min(backoff*2, surveyMaxBackoff)
+			continue
+		}
+		backoff = surveyInitialBackoff
+		failures = 0
+
+		for _, v := range res {
+			e, ok := v.(*MB_RL7023_11.EEDSCAN)
+			if !ok {
+				return fmt.Errorf("%w: %T", MB_RL7023_11.ErrUnexpectedOutput, v)
+			}
+			s.add(e)
+		}
+		s.Rounds++
+		logger.Info("ED scan finished", "round", s.Rounds)
+	}
+	return nil
+}
+
+func (s *survey) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "CHANNEL\tSAMPLES\tMIN\tAVG\tMAX\t\n")
+	for _, c := range s.Channels {
+		if c.Samples == 0 {
+			fmt.Fprintf(tw, "%02X\t0\t-\t-\t-\t\n", c.Channel)
+			continue
+		}
+		fmt.Fprintf(tw, "%02X\t%d\t%.1f\t%.1f\t%.1f\t\n", c.Channel, c.Samples, c.Min, c.Avg, c.Max)
+	}
+	return tw.Flush()
+}
+
+func (s *survey) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
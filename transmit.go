@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/MB_RL7023_11"
+	"github.com/rokoucha/akizuki-dg-route-b-exporter/module"
+)
+
+// 残りの送信時間がこの割合を下回ったら警告する
+const transmitWarnRatio = 0.1
+
+// 直近 1 時間の送信時間
+type transmitMetrics struct {
+	used      prometheus.Gauge
+	remaining prometheus.Gauge
+	warned    bool
+}
+
+func newTransmitMetrics(r prometheus.Registerer) (*transmitMetrics, error) {
+	t := &transmitMetrics{
+		used: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "smartmeter",
+			Name:      "module_transmit_time_used_seconds",
+			Help:      "Transmit time of the Wi-SUN module in the last hour.",
+		}),
+		remaining: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "smartmeter",
+			Name:      "module_transmit_time_remaining_seconds",
+			Help:      "Transmit time left before the Wi-SUN module hits the hourly transmit time limit.",
+		}),
+	}
+	for _, c := range []prometheus.Collector{t.used, t.remaining} {
+		err := r.Register(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// 積算送信時間を読み出してメトリクスを更新します。残りが少なくなったら一度だけ警告します。
+func (t *transmitMetrics) sample(ctx context.Context, mb module.Module, logger *slog.Logger) {
+	usage, err := mb.TransmitUsage(ctx)
+	if err != nil {
+		logger.Warn("Failed to read transmit time", "err", err)
+		return
+	}
+
+	t.used.Set(usage.Used.Seconds())
+	t.remaining.Set(usage.Remaining.Seconds())
+
+	low := usage.Remaining < time.Duration(float64(MB_RL7023_11.TransmitTimeBudget)*transmitWarnRatio)
+	if low && !t.warned {
+		logger.Warn("Approaching the hourly transmit time limit", "used", usage.Used, "remaining", usage.Remaining)
+	}
+	t.warned = low
+}